@@ -6,15 +6,28 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
 
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/cmd"
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/utils"
 
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/version"
 	rolloutsPlugin "github.com/argoproj/argo-rollouts/rollout/trafficrouting/plugin/rpc"
 	goPlugin "github.com/hashicorp/go-plugin"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
+// The environment variables set the defaults of the flags. The rollouts controller does not pass arguments to plugins,
+// but they inherit its environment.
+const (
+	metricsPortEnv = "CONSUL_PLUGIN_METRICS_PORT"
+	logLevelEnv    = "CONSUL_PLUGIN_LOG_LEVEL"
+	logFormatEnv   = "CONSUL_PLUGIN_LOG_FORMAT"
+)
+
 // handshakeConfigs are used to just do a basic handshake between
 // a plugin and host. If the handshake fails, a user friendly error is shown.
 // This prevents users from executing bad plugins or executing a plugin
@@ -26,9 +39,23 @@ var handshakeConfig = goPlugin.HandshakeConfig{
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := cmd.Commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Create a flag to print the version of the plugin
 	// This is useful for debugging and support
 	versionFlag := flag.Bool("version", false, "Print the version of the plugin")
+	metricsPort := flag.Int("metrics-port", utils.EnvInt(metricsPortEnv), "Port to serve Prometheus metrics on, 0 disables metrics. Defaults to $"+metricsPortEnv)
+	logLevel := flag.String("log-level", utils.EnvOrDefault(logLevelEnv, "info"), "Log level, one of trace, debug, info, warn or error. Defaults to $"+logLevelEnv)
+	logFormat := flag.String("log-format", utils.EnvOrDefault(logFormatEnv, "text"), "Log format, text or json. Defaults to $"+logFormatEnv)
+	clientFlags := cmd.AddClientFlags(flag.CommandLine)
 	flag.Parse()
 	if *versionFlag {
 		fmt.Println(version.GetHumanVersion())
@@ -36,10 +63,24 @@ func main() {
 	}
 
 	logCtx := log.WithFields(log.Fields{"plugin": "trafficrouter"})
-	log.SetLevel(log.InfoLevel)
+	if err := configureLogging(*logLevel, *logFormat); err != nil {
+		logCtx.WithError(err).Fatal("Invalid logging configuration")
+	}
 
-	rpcPluginImp := &plugin.RpcPlugin{
-		LogCtx: logCtx,
+	rpcPluginImp := clientFlags.Plugin(logCtx)
+
+	if *metricsPort > 0 {
+		registry := prometheus.NewRegistry()
+		metrics, err := plugin.NewMetrics(registry)
+		if err != nil {
+			logCtx.WithError(err).Fatal("Failed to register metrics")
+		}
+		rpcPluginImp.Metrics = metrics
+		go func() {
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", *metricsPort), plugin.MetricsHandler(registry)); err != nil {
+				logCtx.WithError(err).Error("Metrics server stopped")
+			}
+		}()
 	}
 
 	var pluginMap = map[string]goPlugin.Plugin{
@@ -51,3 +92,21 @@ func main() {
 		Plugins:         pluginMap,
 	})
 }
+
+// configureLogging sets the level and format of the standard logger
+func configureLogging(level, format string) error {
+	parsedLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(parsedLevel)
+	switch format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
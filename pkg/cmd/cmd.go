@@ -0,0 +1,38 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cmd implements the subcommands of the plugin binary, run by operators from the command line.
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Command runs a subcommand with its arguments, writing its output to stdout
+type Command func(args []string, stdout io.Writer) error
+
+// Commands are the subcommands of the plugin binary by name
+var Commands = map[string]Command{
+	"preflight": Preflight,
+	"reset":     Reset,
+	"schema":    Schema,
+	"status":    Status,
+	"validate":  Validate,
+}
+
+// parseRolloutRef parses a <namespace>/<rollout> argument
+func parseRolloutRef(ref string) (string, string, error) {
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("invalid rollout %q, expected <namespace>/<rollout>", ref)
+	}
+	return namespace, name, nil
+}
+
+func newLogCtx() *log.Entry {
+	return log.WithFields(log.Fields{"plugin": "trafficrouter"})
+}
@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"flag"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/utils"
+)
+
+// The environment variables set the defaults of the flags. The rollouts controller does not pass arguments to plugins,
+// but they inherit its environment.
+const (
+	settingsFileEnv      = "CONSUL_PLUGIN_SETTINGS_FILE"
+	settingsConfigMapEnv = "CONSUL_PLUGIN_SETTINGS_CONFIGMAP"
+
+	kubeContextEnv       = "CONSUL_PLUGIN_KUBE_CONTEXT"
+	kubeUserAgentEnv     = "CONSUL_PLUGIN_KUBE_USER_AGENT"
+	kubeQPSEnv           = "CONSUL_PLUGIN_KUBE_QPS"
+	kubeBurstEnv         = "CONSUL_PLUGIN_KUBE_BURST"
+	consulKubeconfigEnv  = "CONSUL_PLUGIN_CONSUL_KUBECONFIG"
+	consulKubeContextEnv = "CONSUL_PLUGIN_CONSUL_KUBE_CONTEXT"
+)
+
+// ClientFlags are the flags selecting the clusters the plugin connects to and its settings
+type ClientFlags struct {
+	settingsFile      *string
+	settingsConfigMap *string
+	kubeconfig        *string
+	kubeContext       *string
+	kubeUserAgent     *string
+	kubeQPS           *float64
+	kubeBurst         *int
+	consulKubeconfig  *string
+	consulKubeContext *string
+}
+
+// AddClientFlags defines the client flags on fs
+func AddClientFlags(fs *flag.FlagSet) *ClientFlags {
+	return &ClientFlags{
+		settingsFile:      fs.String("settings-file", os.Getenv(settingsFileEnv), "Path of a YAML or JSON file with the plugin settings. Defaults to $"+settingsFileEnv),
+		settingsConfigMap: fs.String("settings-configmap", os.Getenv(settingsConfigMapEnv), "ConfigMap holding the plugin settings in its config.yaml key, as <namespace>/<name>. Defaults to $"+settingsConfigMapEnv),
+		kubeconfig:        fs.String("kubeconfig", "", "Path of the kubeconfig file. Defaults to $KUBECONFIG, the default locations, then the in-cluster config"),
+		kubeContext:       fs.String("kube-context", os.Getenv(kubeContextEnv), "Kubeconfig context to use instead of the current context. Defaults to $"+kubeContextEnv),
+		kubeUserAgent:     fs.String("kube-user-agent", os.Getenv(kubeUserAgentEnv), "User agent of the requests to the API server. Defaults to $"+kubeUserAgentEnv),
+		kubeQPS:           fs.Float64("kube-qps", utils.EnvFloat(kubeQPSEnv), "Queries per second to the API server, 0 uses the client-go default. Defaults to $"+kubeQPSEnv),
+		kubeBurst:         fs.Int("kube-burst", utils.EnvInt(kubeBurstEnv), "Burst of queries to the API server, 0 uses the client-go default. Defaults to $"+kubeBurstEnv),
+		consulKubeconfig:  fs.String("consul-kubeconfig", os.Getenv(consulKubeconfigEnv), "Path of the kubeconfig file of a cluster holding the Consul CRDs, if it is not the cluster of the rollouts controller. Defaults to $"+consulKubeconfigEnv),
+		consulKubeContext: fs.String("consul-kube-context", os.Getenv(consulKubeContextEnv), "Kubeconfig context of a cluster holding the Consul CRDs, if it is not the cluster of the rollouts controller. Defaults to $"+consulKubeContextEnv),
+	}
+}
+
+// Plugin returns a plugin configured by the flags. Its clients are created by InitPlugin or InitCLI.
+func (f *ClientFlags) Plugin(logCtx *logrus.Entry) *plugin.RpcPlugin {
+	kubeConfig := utils.KubeConfigOptions{
+		Kubeconfig: *f.kubeconfig,
+		Context:    *f.kubeContext,
+		UserAgent:  *f.kubeUserAgent,
+		QPS:        float32(*f.kubeQPS),
+		Burst:      *f.kubeBurst,
+	}
+	p := &plugin.RpcPlugin{
+		LogCtx:            logCtx,
+		SettingsFile:      *f.settingsFile,
+		SettingsConfigMap: *f.settingsConfigMap,
+		KubeConfig:        kubeConfig,
+	}
+	if *f.consulKubeconfig != "" || *f.consulKubeContext != "" {
+		consulKubeConfig := kubeConfig
+		consulKubeConfig.Kubeconfig = *f.consulKubeconfig
+		consulKubeConfig.Context = *f.consulKubeContext
+		p.ConsulKubeConfig = &consulKubeConfig
+	}
+	return p
+}
@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+)
+
+const preflightTimeout = 60 * time.Second
+
+// Preflight checks the permissions, CRDs and Consul controller the plugin depends on
+func Preflight(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: preflight [flags]")
+		fs.PrintDefaults()
+	}
+	as := fs.String("as", "", "User to check the permissions of, for example system:serviceaccount:argo-rollouts:argo-rollouts. Defaults to the user of the kubeconfig")
+	clientFlags := AddClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("preflight takes no arguments")
+	}
+
+	p := clientFlags.Plugin(newLogCtx())
+	p.KubeConfig.Impersonate = *as
+	if p.ConsulKubeConfig != nil {
+		p.ConsulKubeConfig.Impersonate = *as
+	}
+	if err := p.InitCLI(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	return printPreflight(p.Preflight(ctx), stdout)
+}
+
+// printPreflight writes the table of the checks, and fails if any did not pass
+func printPreflight(checks []plugin.PreflightCheck, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tMESSAGE")
+	failed := 0
+	for _, check := range checks {
+		result := "PASS"
+		if !check.Passed {
+			result = "FAIL"
+			failed++
+		}
+		message := check.Message
+		if message == "" {
+			message = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Check, result, message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d preflight checks failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+)
+
+func TestPrintPreflight(t *testing.T) {
+	var output bytes.Buffer
+	err := printPreflight([]plugin.PreflightCheck{
+		{Check: "RBAC servicesplitters.consul.hashicorp.com", Passed: true},
+		{Check: "RBAC events", Message: "create denied, add them to the ClusterRole of the plugin"},
+		{Check: "Consul config entries synced", Passed: true, Message: "2 service splitters and resolvers synced"},
+	}, &output)
+	require.EqualError(t, err, "1 of 3 preflight checks failed")
+	require.Equal(t, `CHECK                                        RESULT   MESSAGE
+RBAC servicesplitters.consul.hashicorp.com   PASS     -
+RBAC events                                  FAIL     create denied, add them to the ClusterRole of the plugin
+Consul config entries synced                 PASS     2 service splitters and resolvers synced
+`, output.String())
+
+	output.Reset()
+	require.NoError(t, printPreflight([]plugin.PreflightCheck{{Check: "RBAC events", Passed: true}}, &output))
+}
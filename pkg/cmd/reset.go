@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const resetTimeout = 60 * time.Second
+
+// Reset returns the Consul routing of a rollout to its stable version, after asking for confirmation
+func Reset(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: reset [flags] <namespace>/<rollout>")
+		fs.PrintDefaults()
+	}
+	yes := fs.Bool("yes", false, "Reset without asking for confirmation")
+	dryRun := fs.Bool("dry-run", false, "Log the changes instead of writing them")
+	clientFlags := AddClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single <namespace>/<rollout> argument")
+	}
+	namespace, name, err := parseRolloutRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if !*dryRun && !*yes {
+		confirmed, err := confirm(stdin, stdout, fmt.Sprintf("Send all traffic of rollout %s/%s to its stable version and remove the routes managed by the plugin?", namespace, name))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return errors.New("reset cancelled")
+		}
+	}
+
+	p := clientFlags.Plugin(newLogCtx())
+	if err := p.InitCLI(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+	defer cancel()
+	if err := p.Reset(ctx, namespace, name, *dryRun); err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(stdout, "Dry run of the reset of rollout %s/%s complete, nothing was changed\n", namespace, name)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Rollout %s/%s reset to its stable version\n", namespace, name)
+	return nil
+}
+
+// confirm asks question on w and reports whether the answer read from r is yes
+func confirm(r io.Reader, w io.Writer, question string) (bool, error) {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
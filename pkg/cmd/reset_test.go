@@ -0,0 +1,31 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirm(t *testing.T) {
+	for answer, expected := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false} {
+		var output bytes.Buffer
+		confirmed, err := confirm(strings.NewReader(answer), &output, "Reset?")
+		require.NoError(t, err)
+		require.Equal(t, expected, confirmed, answer)
+		require.Equal(t, "Reset? [y/N] ", output.String())
+	}
+}
+
+func TestResetCancelled(t *testing.T) {
+	stdin = strings.NewReader("n\n")
+	t.Cleanup(func() { stdin = os.Stdin })
+	var output bytes.Buffer
+	require.EqualError(t, Reset([]string{"default/rollout"}, &output), "reset cancelled")
+	require.Equal(t, "Send all traffic of rollout default/rollout to its stable version and remove the routes managed by the plugin? [y/N] ", output.String())
+}
@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+)
+
+// Schema prints the JSON Schema of the plugin configuration of a rollout
+func Schema(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: schema")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("schema takes no arguments")
+	}
+	schema, err := plugin.ConfigSchema()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, string(schema))
+	return err
+}
@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+)
+
+const statusTimeout = 30 * time.Second
+
+// Status prints the live Consul routing of a rollout
+func Status(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: status [flags] <namespace>/<rollout>")
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "table", "Output format, table or json")
+	clientFlags := AddClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single <namespace>/<rollout> argument")
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q, expected table or json", *output)
+	}
+	namespace, name, err := parseRolloutRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	p := clientFlags.Plugin(newLogCtx())
+	if err := p.InitCLI(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+	status, err := p.RoutingStatus(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	return printStatus(status, *output, stdout)
+}
+
+func printStatus(status *plugin.RoutingStatus, output string, w io.Writer) error {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	}
+
+	lockOwner := status.LockOwner
+	if lockOwner == "" {
+		lockOwner = "-"
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(tw, "Rollout:\t%s/%s\n", status.Namespace, status.Rollout)
+	fmt.Fprintf(tw, "Service:\t%s\n", status.Service)
+	fmt.Fprintf(tw, "Lock owner:\t%s\n", lockOwner)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "SUBSET\tROLE\tWEIGHT\tFILTER")
+	for _, subset := range status.Subsets {
+		fmt.Fprintf(tw, "%s\t%s\t%g\t%s\n", subset.Name, subset.Role, subset.Weight, subset.Filter)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "KIND\tNAME\tSYNCED\tLAST SYNCED\tMESSAGE")
+	for _, entry := range []plugin.ConfigEntryStatus{status.Splitter, status.Resolver} {
+		lastSynced := "-"
+		if entry.LastSynced != nil {
+			lastSynced = entry.LastSynced.UTC().Format(time.RFC3339)
+		}
+		message := entry.Message
+		if message == "" {
+			message = "-"
+		}
+		if entry.Reason != "" {
+			message = fmt.Sprintf("%s: %s", entry.Reason, entry.Message)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Kind, entry.Name, entry.Synced, lastSynced, message)
+	}
+	return tw.Flush()
+}
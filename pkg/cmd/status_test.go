@@ -0,0 +1,69 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+)
+
+func testStatus() *plugin.RoutingStatus {
+	lastSynced := metav1.NewTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	return &plugin.RoutingStatus{
+		Namespace: "default",
+		Rollout:   "rollout",
+		Service:   "test-service",
+		LockOwner: "rollout",
+		Subsets: []plugin.SubsetStatus{
+			{Name: "canary", Role: "canary", Weight: 30, Filter: "Service.Meta.version == 2"},
+			{Name: "stable", Role: "stable", Weight: 70, Filter: "Service.Meta.version == 1"},
+		},
+		Splitter: plugin.ConfigEntryStatus{Kind: "ServiceSplitter", Name: "test-service", Synced: "True", LastSynced: &lastSynced},
+		Resolver: plugin.ConfigEntryStatus{Kind: "ServiceResolver", Name: "test-service", Synced: "False", Reason: "ConsulAgentError", Message: "connection refused"},
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	var table bytes.Buffer
+	require.NoError(t, printStatus(testStatus(), "table", &table))
+	require.Equal(t, `Rollout:      default/rollout
+Service:      test-service
+Lock owner:   rollout
+
+SUBSET   ROLE     WEIGHT   FILTER
+canary   canary   30       Service.Meta.version == 2
+stable   stable   70       Service.Meta.version == 1
+
+KIND              NAME           SYNCED   LAST SYNCED            MESSAGE
+ServiceSplitter   test-service   True     2024-05-01T12:00:00Z   -
+ServiceResolver   test-service   False    -                      ConsulAgentError: connection refused
+`, table.String())
+
+	var output bytes.Buffer
+	require.NoError(t, printStatus(testStatus(), "json", &output))
+	require.JSONEq(t, `{
+		"namespace": "default",
+		"rollout": "rollout",
+		"service": "test-service",
+		"lockOwner": "rollout",
+		"subsets": [
+			{"name": "canary", "role": "canary", "weight": 30, "filter": "Service.Meta.version == 2"},
+			{"name": "stable", "role": "stable", "weight": 70, "filter": "Service.Meta.version == 1"}
+		],
+		"splitter": {"kind": "ServiceSplitter", "name": "test-service", "synced": "True", "lastSynced": "2024-05-01T12:00:00Z"},
+		"resolver": {"kind": "ServiceResolver", "name": "test-service", "synced": "False", "reason": "ConsulAgentError", "message": "connection refused"}
+	}`, output.String())
+}
+
+func TestStatusArguments(t *testing.T) {
+	var output bytes.Buffer
+	require.EqualError(t, Status([]string{"rollout"}, &output), `invalid rollout "rollout", expected <namespace>/<rollout>`)
+	require.EqualError(t, Status([]string{"-o", "yaml", "default/rollout"}, &output), `unknown output format "yaml", expected table or json`)
+}
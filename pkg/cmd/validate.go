@@ -0,0 +1,123 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/plugin"
+)
+
+// stdin is read by the subcommands, and replaced in tests
+var stdin io.Reader = os.Stdin
+
+// Validate checks Rollout, ServiceResolver and ServiceSplitter manifests offline, with the Deployments the rollouts
+// reference. It reads the files given as arguments, or stdin if there are none or for the - argument.
+func Validate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: validate [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+	settingsFile := fs.String("settings-file", os.Getenv(settingsFileEnv), "Path of a YAML or JSON file with the plugin settings. Defaults to $"+settingsFileEnv)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	manifests := plugin.Manifests{}
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		if err := decodeManifests(data, &manifests); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	p := &plugin.RpcPlugin{SettingsFile: *settingsFile}
+	if err := p.InitOffline(); err != nil {
+		return err
+	}
+	validationErrors := p.Validate(manifests)
+	for _, validationError := range validationErrors {
+		fmt.Fprintln(stdout, validationError.Error())
+	}
+	if len(validationErrors) > 0 {
+		return fmt.Errorf("validation failed with %d errors", len(validationErrors))
+	}
+	fmt.Fprintf(stdout, "%d rollouts, %d service resolvers and %d service splitters are valid\n",
+		len(manifests.Rollouts), len(manifests.Resolvers), len(manifests.Splitters))
+	return nil
+}
+
+// decodeManifests adds the Rollouts, ServiceResolvers, ServiceSplitters and Deployments of a YAML stream or JSON
+// document to manifests. Objects of other kinds are skipped.
+func decodeManifests(data []byte, manifests *plugin.Manifests) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var raw map[string]interface{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if raw == nil {
+			continue
+		}
+		document, err := yaml.Marshal(raw)
+		if err != nil {
+			return err
+		}
+		kind, _ := raw["kind"].(string)
+		switch kind {
+		case "Rollout":
+			rollout := &v1alpha1.Rollout{}
+			if err := yaml.UnmarshalStrict(document, rollout); err != nil {
+				return fmt.Errorf("invalid Rollout: %w", err)
+			}
+			manifests.Rollouts = append(manifests.Rollouts, rollout)
+		case "ServiceResolver":
+			resolver := &consulv1aplha1.ServiceResolver{}
+			if err := yaml.UnmarshalStrict(document, resolver); err != nil {
+				return fmt.Errorf("invalid ServiceResolver: %w", err)
+			}
+			manifests.Resolvers = append(manifests.Resolvers, resolver)
+		case "ServiceSplitter":
+			splitter := &consulv1aplha1.ServiceSplitter{}
+			if err := yaml.UnmarshalStrict(document, splitter); err != nil {
+				return fmt.Errorf("invalid ServiceSplitter: %w", err)
+			}
+			manifests.Splitters = append(manifests.Splitters, splitter)
+		case "Deployment":
+			deployment := &appsv1.Deployment{}
+			if err := yaml.UnmarshalStrict(document, deployment); err != nil {
+				return fmt.Errorf("invalid Deployment: %w", err)
+			}
+			manifests.Deployments = append(manifests.Deployments, deployment)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testManifests = `
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: static-server
+spec:
+  template:
+    metadata:
+      annotations:
+        consul.hashicorp.com/service-meta-version: "2"
+  strategy:
+    canary:
+      trafficRouting:
+        plugins:
+          hashicorp/consul:
+            stableSubsetName: stable
+            canarySubsetName: canary
+            serviceName: static-server
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: static-server
+---
+apiVersion: consul.hashicorp.com/v1alpha1
+kind: ServiceResolver
+metadata:
+  name: static-server
+spec:
+  subsets:
+    stable:
+      filter: Service.Meta.version == 1
+    canary:
+      filter: ""
+`
+
+const testSplitter = `
+apiVersion: consul.hashicorp.com/v1alpha1
+kind: ServiceSplitter
+metadata:
+  name: static-server
+spec:
+  splits:
+    - weight: %d
+      serviceSubset: stable
+    - weight: 0
+      serviceSubset: canary
+`
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	splitterFile := filepath.Join(dir, "splitter.yaml")
+	require.NoError(t, os.WriteFile(splitterFile, []byte(fmt.Sprintf(testSplitter, 100)), 0o600))
+	invalidSplitterFile := filepath.Join(dir, "invalid-splitter.yaml")
+	require.NoError(t, os.WriteFile(invalidSplitterFile, []byte(fmt.Sprintf(testSplitter, 90)), 0o600))
+	stdin = strings.NewReader(testManifests)
+	t.Cleanup(func() { stdin = os.Stdin })
+
+	var output bytes.Buffer
+	require.NoError(t, Validate([]string{"-", splitterFile}, &output))
+	require.Equal(t, "1 rollouts, 1 service resolvers and 1 service splitters are valid\n", output.String())
+
+	stdin = strings.NewReader(testManifests)
+	output.Reset()
+	require.EqualError(t, Validate([]string{"-", invalidSplitterFile}, &output), "validation failed with 1 errors")
+	require.Equal(t, "ServiceSplitter default/static-server: the weights of spec.splits add up to 90, expected 100\n", output.String())
+}
+
+func TestDecodeManifestsUnknownField(t *testing.T) {
+	stdin = strings.NewReader("kind: ServiceResolver\nmetadata:\n  name: static-server\nspec:\n  subset: {}\n")
+	t.Cleanup(func() { stdin = os.Stdin })
+	err := Validate(nil, &bytes.Buffer{})
+	require.ErrorContains(t, err, `-: invalid ServiceResolver: error unmarshaling JSON: while decoding JSON: json: unknown field "subset"`)
+}
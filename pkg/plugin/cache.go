@@ -0,0 +1,81 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newCachedClient returns a client reading the Consul config entries from an informer cache, scoped to namespaces if
+// any are given. All other kinds and every write go to the API server. The cache runs until ctx is done.
+func newCachedClient(ctx context.Context, cfg *rest.Config, s *runtime.Scheme, namespaces []string) (client.Client, error) {
+	opts := cache.Options{Scheme: s}
+	if len(namespaces) > 0 {
+		opts.DefaultNamespaces = map[string]cache.Config{}
+		for _, namespace := range namespaces {
+			opts.DefaultNamespaces[namespace] = cache.Config{}
+		}
+	}
+	informerCache, err := cache.New(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	syncCtx, cancel := context.WithTimeout(ctx, defaultRPCTimeout)
+	defer cancel()
+	// Start the informers of the config entries read on every SetWeight, the others start on their first read
+	for _, obj := range []client.Object{&consulv1aplha1.ServiceResolver{}, &consulv1aplha1.ServiceSplitter{}} {
+		if _, err := informerCache.GetInformer(syncCtx, obj); err != nil {
+			return nil, err
+		}
+	}
+	go func() {
+		_ = informerCache.Start(ctx)
+	}()
+	if !informerCache.WaitForCacheSync(syncCtx) {
+		return nil, errors.New("the cache of consul config entries could not be synced")
+	}
+	return client.New(cfg, client.Options{
+		Scheme: s,
+		Cache: &client.CacheOptions{
+			Reader:     informerCache,
+			DisableFor: []client.Object{&v1alpha1.Rollout{}, &corev1.ConfigMap{}},
+		},
+	})
+}
+
+// readLive replaces obj with its current state on the API server, when reads are served from a cache
+func (r *RpcPlugin) readLive(ctx context.Context, obj client.Object) (bool, error) {
+	if r.APIReader == nil {
+		return false, nil
+	}
+	return true, r.APIReader.Get(ctx, client.ObjectKeyFromObject(obj), obj, &client.GetOptions{})
+}
+
+// configEntryChanged reports whether the spec or the annotations of a config entry differ from the ones it was read with
+func configEntryChanged(original, desired metav1.Object, originalSpec, desiredSpec interface{}) bool {
+	return !reflect.DeepEqual(originalSpec, desiredSpec) || !reflect.DeepEqual(original.GetAnnotations(), desired.GetAnnotations())
+}
+
+// updateConfigEntry writes obj. When obj was read from a cache that is out of date, the API server rejects the write
+// as a conflict on its resourceVersion.
+func (r *RpcPlugin) updateConfigEntry(ctx context.Context, kind string, obj client.Object) error {
+	err := r.K8SClient.Update(ctx, obj, &client.UpdateOptions{})
+	if k8serrors.IsConflict(err) {
+		return fmt.Errorf("%s %s changed since it was read, the call will be retried: %w", kind, client.ObjectKeyFromObject(obj), err)
+	}
+	return err
+}
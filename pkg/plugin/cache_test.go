@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// laggingCacheClient serves reads like a cache that has not caught up with the API server yet
+type laggingCacheClient struct {
+	client.Client
+	lag func(obj client.Object)
+}
+
+func (c laggingCacheClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.Client.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	c.lag(obj)
+	return nil
+}
+
+func TestSetWeightCachedReads(t *testing.T) {
+	testCases := map[string]struct {
+		lag                  func(obj client.Object)
+		expectedError        string
+		expectedCanaryWeight float32
+	}{
+		"cache up to date": {
+			lag:                  func(client.Object) {},
+			expectedCanaryWeight: 30,
+		},
+		"cached splitter out of date": {
+			lag: func(obj client.Object) {
+				if splitter, ok := obj.(*consulv1aplha1.ServiceSplitter); ok {
+					splitter.ResourceVersion = "1"
+				}
+			},
+			expectedError:        `ServiceSplitter default/test-service changed since it was read, the call will be retried: Operation cannot be fulfilled on servicesplitters.consul.hashicorp.com "test-service": object was modified`,
+			expectedCanaryWeight: 0,
+		},
+		"cached resolver not synced yet": {
+			lag: func(obj client.Object) {
+				if resolver, ok := obj.(*consulv1aplha1.ServiceResolver); ok {
+					resolver.Status.Conditions[0].Status = corev1.ConditionFalse
+				}
+			},
+			expectedCanaryWeight: 30,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			apiServer := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+			p := &RpcPlugin{
+				K8SClient: laggingCacheClient{Client: apiServer, lag: testCase.lag},
+				APIReader: apiServer,
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+			rpcErr := p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 30), 30, []v1alpha1.WeightDestination{})
+			require.Equal(t, testCase.expectedError, rpcErr.ErrorString)
+
+			actualSplitter := &consulv1aplha1.ServiceSplitter{}
+			require.NoError(t, apiServer.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualSplitter, &client.GetOptions{}))
+			weight, _ := splitWeight(actualSplitter, "canary")
+			require.Equal(t, testCase.expectedCanaryWeight, weight)
+		})
+	}
+}
+
+// countingReader counts the reads from the API server
+type countingReader struct {
+	client.Reader
+	reads *int
+}
+
+func (r countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	*r.reads++
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+func TestSetWeightCachedNoOp(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	writes, reads := 0, 0
+	countWrite := func() { writes++ }
+	apiServer := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			countWrite()
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			countWrite()
+			return c.Update(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			countWrite()
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			countWrite()
+			return c.Delete(ctx, obj, opts...)
+		},
+	}).Build()
+	p := &RpcPlugin{
+		K8SClient: apiServer,
+		APIReader: countingReader{Reader: apiServer, reads: &reads},
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	rpcErr := p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 30), 30, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	require.Equal(t, 2, writes)
+	require.Zero(t, reads)
+
+	// Setting the same weight again changes nothing, so nothing is read from or written to the API server
+	writes = 0
+	rpcErr = p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 30), 30, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	require.Zero(t, writes)
+	require.Zero(t, reads)
+}
+
+func TestSetWeightUncachedSyncCheck(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	unsyncedResolver := defaultResolver()
+	unsyncedResolver.Status.Conditions[0].Status = corev1.ConditionFalse
+	p := &RpcPlugin{
+		K8SClient: fake.NewClientBuilder().WithScheme(s).WithObjects(unsyncedResolver, defaultSplitter()).Build(),
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	rpcErr := p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 30), 30, []v1alpha1.WeightDestination{})
+	require.Equal(t, "service resolver has not synced with Consul. The service resolver needs to be up to date before rollout can continue", rpcErr.ErrorString)
+}
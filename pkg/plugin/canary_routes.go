@@ -0,0 +1,156 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// canaryRoutesServiceSuffix is appended to the service name to name the virtual service used for path-scoped canaries
+const canaryRoutesServiceSuffix = "-canary-routes"
+
+// CanaryRouteMatch selects the HTTP requests that take part in a path-scoped canary. At most one of the path fields may
+// be set, and at least one of the path fields or Methods must be set.
+type CanaryRouteMatch struct {
+	PathExact  string   `json:"pathExact,omitempty" protobuf:"bytes,1,opt,name=pathExact"`
+	PathPrefix string   `json:"pathPrefix,omitempty" protobuf:"bytes,2,opt,name=pathPrefix"`
+	PathRegex  string   `json:"pathRegex,omitempty" protobuf:"bytes,3,opt,name=pathRegex"`
+	Methods    []string `json:"methods,omitempty" protobuf:"bytes,4,rep,name=methods"`
+}
+
+func validateCanaryRoutes(matches []CanaryRouteMatch) error {
+	for i, m := range matches {
+		paths := 0
+		for _, p := range []string{m.PathExact, m.PathPrefix, m.PathRegex} {
+			if p != "" {
+				paths++
+			}
+		}
+		if paths > 1 {
+			return fmt.Errorf("invalid consul traffic routing configuration. canaryRoutes[%d] must set at most one of pathExact, pathPrefix and pathRegex", i)
+		}
+		if paths == 0 && len(m.Methods) == 0 {
+			return fmt.Errorf("invalid consul traffic routing configuration. canaryRoutes[%d] must set a path or methods", i)
+		}
+	}
+	return nil
+}
+
+// canaryRoutesServiceName returns the name of the virtual service whose splitter divides the matched requests
+func (c *ConsulTrafficRouting) canaryRoutesServiceName() string {
+	if c.CanaryRoutesServiceName != "" {
+		return c.CanaryRoutesServiceName
+	}
+	return c.ServiceName + canaryRoutesServiceSuffix
+}
+
+// canaryRoutes returns the ServiceRouter routes sending the matched requests to the canary routes virtual service
+func canaryRoutes(matches []CanaryRouteMatch, routesServiceName string) []consulv1aplha1.ServiceRoute {
+	routes := make([]consulv1aplha1.ServiceRoute, 0, len(matches))
+	for _, m := range matches {
+		routes = append(routes, consulv1aplha1.ServiceRoute{
+			Match: &consulv1aplha1.ServiceRouteMatch{
+				HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+					PathExact:  m.PathExact,
+					PathPrefix: m.PathPrefix,
+					PathRegex:  m.PathRegex,
+					Methods:    m.Methods,
+				},
+			},
+			Destination: &consulv1aplha1.ServiceRouteDestination{
+				Service: routesServiceName,
+			},
+		})
+	}
+	return routes
+}
+
+// reconcileCanaryRoutesSplitter writes splits to the plugin-managed ServiceSplitter of the canary routes virtual
+// service, creating it if needed. When splits is empty a splitter created by the plugin is deleted.
+func (r *RpcPlugin) reconcileCanaryRoutesSplitter(ctx context.Context, namespace, name string, splits []consulv1aplha1.ServiceSplit) error {
+	serviceSplitter := &consulv1aplha1.ServiceSplitter{}
+	err := r.K8SClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, serviceSplitter, &client.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		if len(splits) == 0 {
+			return nil
+		}
+		serviceSplitter = &consulv1aplha1.ServiceSplitter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{createdByPluginAnnotation: "true"},
+			},
+			Spec: consulv1aplha1.ServiceSplitterSpec{Splits: splits},
+		}
+		return r.K8SClient.Create(ctx, serviceSplitter, &client.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if serviceSplitter.Annotations[createdByPluginAnnotation] != "true" {
+		return fmt.Errorf("service splitter %s already exists and was not created by the plugin. Set canaryRoutesServiceName to an unused service name", name)
+	}
+	if len(splits) == 0 {
+		return r.K8SClient.Delete(ctx, serviceSplitter, &client.DeleteOptions{})
+	}
+	if err := validateSplitterSyncStatus(serviceSplitter, r.Settings.syncTimeout()); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(serviceSplitter.Spec.Splits, splits) {
+		return nil
+	}
+	serviceSplitter.Spec.Splits = splits
+	r.LogCtx.WithField("serviceSplitter", serviceSplitter).Debug("Updating canary routes ServiceSplitter")
+	return r.K8SClient.Update(ctx, serviceSplitter, &client.UpdateOptions{})
+}
+
+// canaryRoutesWeight returns the canary weight of the matched requests, from the splitter of the canary routes virtual
+// service. It is 0 until the plugin creates the splitter.
+func (r *RpcPlugin) canaryRoutesWeight(ctx context.Context, namespace string, cfg *ConsulTrafficRouting) (float32, error) {
+	serviceSplitter := &consulv1aplha1.ServiceSplitter{}
+	err := r.K8SClient.Get(ctx, types.NamespacedName{Name: cfg.canaryRoutesServiceName(), Namespace: namespace}, serviceSplitter, &client.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, split := range serviceSplitter.Spec.Splits {
+		if split.ServiceSubset == cfg.CanarySubsetName || split.Service == cfg.canaryOptionsServiceName() {
+			return split.Weight, nil
+		}
+	}
+	return 0, nil
+}
+
+// canaryRoutesSplits derives the splits of the canary routes splitter from the splits of the service splitter, so that
+// the matched requests are divided by the desired weight and carry the same header modifiers. With route options the
+// canary share is sent to the canary options virtual service, whose router forwards it to the canary subset.
+func canaryRoutesSplits(cfg *ConsulTrafficRouting, splits []consulv1aplha1.ServiceSplit, desiredWeight int32) []consulv1aplha1.ServiceSplit {
+	routesSplits := make([]consulv1aplha1.ServiceSplit, 0, len(splits))
+	for _, split := range splits {
+		routesSplit := *split.DeepCopy()
+		routesSplit.Service = cfg.ServiceName
+		if split.ServiceSubset == cfg.CanarySubsetName {
+			routesSplit.Weight = float32(desiredWeight)
+			if cfg.CanaryRouteOptions != nil {
+				routesSplit.Service = cfg.canaryOptionsServiceName()
+				routesSplit.ServiceSubset = ""
+			}
+		} else {
+			routesSplit.Weight = float32(100 - desiredWeight)
+		}
+		routesSplits = append(routesSplits, routesSplit)
+	}
+	return routesSplits
+}
@@ -0,0 +1,149 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetWeightCanaryRoutes(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	config := ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		CanaryRoutes: []CanaryRouteMatch{
+			{PathPrefix: "/v2/"},
+			{PathExact: "/health", Methods: []string{"GET"}},
+		},
+	}
+	jsonConfig, err := json.Marshal(config)
+	require.NoError(t, err)
+
+	serviceName := types.NamespacedName{Name: "test-service", Namespace: "default"}
+	routesName := types.NamespacedName{Name: "test-service-canary-routes", Namespace: "default"}
+
+	// In progress, the matched requests are split by the desired weight and everything else stays on stable
+	rpcErr := p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionFalse, 40), 40, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	actualSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), serviceName, actualSplitter, &client.GetOptions{}))
+	require.ElementsMatch(t, []consulv1aplha1.ServiceSplit{
+		{Weight: 100, ServiceSubset: "stable"},
+		{Weight: 0, ServiceSubset: "canary"},
+	}, actualSplitter.Spec.Splits)
+
+	routesSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), routesName, routesSplitter, &client.GetOptions{}))
+	require.ElementsMatch(t, []consulv1aplha1.ServiceSplit{
+		{Weight: 60, Service: "test-service", ServiceSubset: "stable"},
+		{Weight: 40, Service: "test-service", ServiceSubset: "canary"},
+	}, routesSplitter.Spec.Splits)
+
+	actualRouter := &consulv1aplha1.ServiceRouter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), serviceName, actualRouter, &client.GetOptions{}))
+	require.Equal(t, []consulv1aplha1.ServiceRoute{
+		{
+			Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/v2/"}},
+			Destination: &consulv1aplha1.ServiceRouteDestination{Service: "test-service-canary-routes"},
+		},
+		{
+			Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathExact: "/health", Methods: []string{"GET"}}},
+			Destination: &consulv1aplha1.ServiceRouteDestination{Service: "test-service-canary-routes"},
+		},
+	}, actualRouter.Spec.Routes)
+
+	// Once complete, the routes and the canary routes splitter are removed
+	rpcErr = p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionTrue, 0), 0, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	require.True(t, k8serrors.IsNotFound(k8sClient.Get(context.TODO(), routesName, &consulv1aplha1.ServiceSplitter{}, &client.GetOptions{})))
+	require.True(t, k8serrors.IsNotFound(k8sClient.Get(context.TODO(), serviceName, &consulv1aplha1.ServiceRouter{}, &client.GetOptions{})))
+}
+
+func TestSetWeightCanaryRoutesSafetyLimits(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	config := ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		CanaryRoutes:     []CanaryRouteMatch{{PathPrefix: "/v2/"}},
+		Limits:           &SafetyLimits{MaxWeightIncrease: 20},
+	}
+	jsonConfig, err := json.Marshal(config)
+	require.NoError(t, err)
+
+	// Each step is compared with the weight of the canary routes splitter, not the main splitter left on stable
+	for _, desiredWeight := range []int32{20, 40} {
+		rpcErr := p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionFalse, desiredWeight), desiredWeight, []v1alpha1.WeightDestination{})
+		require.Empty(t, rpcErr.ErrorString)
+	}
+	routesSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service-canary-routes", Namespace: "default"}, routesSplitter, &client.GetOptions{}))
+	weight, _ := splitWeight(routesSplitter, "canary")
+	require.Equal(t, float32(40), weight)
+
+	rpcErr := p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionFalse, 70), 70, []v1alpha1.WeightDestination{})
+	require.Equal(t, "refusing to increase the canary weight from 40 to 70, more than the limit of 20 per step", rpcErr.ErrorString)
+}
+
+func TestValidateCanaryRoutes(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		matches       []CanaryRouteMatch
+		expectedError string
+	}{
+		{
+			testName: "valid",
+			matches:  []CanaryRouteMatch{{PathPrefix: "/v2/"}, {Methods: []string{"POST"}}},
+		},
+		{
+			testName:      "multiple paths",
+			matches:       []CanaryRouteMatch{{PathPrefix: "/v2/", PathExact: "/v2"}},
+			expectedError: "canaryRoutes[0] must set at most one of pathExact, pathPrefix and pathRegex",
+		},
+		{
+			testName:      "empty match",
+			matches:       []CanaryRouteMatch{{PathPrefix: "/v2/"}, {}},
+			expectedError: "canaryRoutes[1] must set a path or methods",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			err := validateCanaryRoutes(testCase.matches)
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, testCase.expectedError)
+			}
+		})
+	}
+}
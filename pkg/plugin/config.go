@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+)
+
+// configMigration upgrades a configuration from the version it is registered under to the version to
+type configMigration struct {
+	to      string
+	migrate func(config map[string]interface{}) error
+}
+
+// configMigrations upgrade configurations of older versions, by the version they upgrade from. A change to the
+// configuration that is not backwards compatible bumps ConfigAPIVersion and registers the migration from the previous
+// version here.
+var configMigrations = map[string]configMigration{}
+
+// pluginConfigData returns the plugin configuration of the rollout, under whichever of the plugin names it is set
+func pluginConfigData(rollout *v1alpha1.Rollout) ([]byte, error) {
+	var found []string
+	var data []byte
+	if usesPlugin(rollout) {
+		for _, key := range ConfigKeys {
+			if config, ok := rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[key]; ok {
+				found = append(found, key)
+				data = config
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("rollout %s/%s has no consul traffic routing configuration. Set it in spec.strategy.canary.trafficRouting.plugins under the name the plugin is registered with, one of %s",
+			rollout.GetNamespace(), rollout.GetName(), strings.Join(ConfigKeys, ", "))
+	case 1:
+		return data, nil
+	default:
+		return nil, fmt.Errorf("invalid consul traffic routing configuration. It is set under both %s, set it only under the name the plugin is registered with", strings.Join(found, " and "))
+	}
+}
+
+// usesPlugin reports whether the rollout configures the plugin under any of its names
+func usesPlugin(rollout *v1alpha1.Rollout) bool {
+	canary := rollout.Spec.Strategy.Canary
+	if canary == nil || canary.TrafficRouting == nil {
+		return false
+	}
+	for _, key := range ConfigKeys {
+		if _, ok := canary.TrafficRouting.Plugins[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateConfig upgrades the decoded configuration to ConfigAPIVersion. A configuration without an apiVersion was
+// written before configurations were versioned and has the layout of the first version.
+func migrateConfig(config map[string]interface{}) error {
+	version, _ := config["apiVersion"].(string)
+	if version == "" {
+		version = ConfigAPIVersion
+	}
+	for version != ConfigAPIVersion {
+		migration, ok := configMigrations[version]
+		if !ok {
+			return fmt.Errorf("invalid consul traffic routing configuration. apiVersion %q is not supported, expected one of %s", version, strings.Join(supportedConfigVersions(), ", "))
+		}
+		if err := migration.migrate(config); err != nil {
+			return fmt.Errorf("invalid consul traffic routing configuration. apiVersion %s could not be migrated to %s: %w", version, migration.to, err)
+		}
+		version = migration.to
+	}
+	config["apiVersion"] = ConfigAPIVersion
+	return nil
+}
+
+// supportedConfigVersions returns the versions a configuration may have
+func supportedConfigVersions() []string {
+	versions := []string{ConfigAPIVersion}
+	for version := range configMigrations {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// migratedConfigData returns data with the configuration upgraded to ConfigAPIVersion. Data that is not a JSON object
+// is returned as is, for decoding to report.
+func migratedConfigData(data []byte) ([]byte, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil || config == nil {
+		return data, nil
+	}
+	if err := migrateConfig(config); err != nil {
+		return nil, err
+	}
+	return json.Marshal(config)
+}
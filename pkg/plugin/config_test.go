@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPluginConfigData(t *testing.T) {
+	config := json.RawMessage(`{"serviceName":"test-service"}`)
+	testCases := map[string]struct {
+		plugins       map[string]json.RawMessage
+		expectedError string
+	}{
+		"config key": {
+			plugins: map[string]json.RawMessage{ConfigKey: config},
+		},
+		"legacy config key": {
+			plugins: map[string]json.RawMessage{LegacyConfigKey: config, "other/plugin": json.RawMessage(`{}`)},
+		},
+		"both keys": {
+			plugins:       map[string]json.RawMessage{ConfigKey: config, LegacyConfigKey: config},
+			expectedError: "invalid consul traffic routing configuration. It is set under both hashicorp/consul and argoproj-labs/consul, set it only under the name the plugin is registered with",
+		},
+		"no config": {
+			plugins:       map[string]json.RawMessage{"other/plugin": json.RawMessage(`{}`)},
+			expectedError: "rollout default/rollout has no consul traffic routing configuration. Set it in spec.strategy.canary.trafficRouting.plugins under the name the plugin is registered with, one of hashicorp/consul, argoproj-labs/consul",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rollout := &v1alpha1.Rollout{
+				ObjectMeta: metav1.ObjectMeta{Name: "rollout", Namespace: "default"},
+				Spec: v1alpha1.RolloutSpec{
+					Strategy: v1alpha1.RolloutStrategy{
+						Canary: &v1alpha1.CanaryStrategy{
+							TrafficRouting: &v1alpha1.RolloutTrafficRouting{Plugins: testCase.plugins},
+						},
+					},
+				},
+			}
+			data, err := pluginConfigData(rollout)
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+				require.Equal(t, []byte(config), data)
+				require.True(t, usesPlugin(rollout))
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+			}
+		})
+	}
+
+	require.False(t, usesPlugin(&v1alpha1.Rollout{}))
+}
+
+func TestMigrateConfig(t *testing.T) {
+	configMigrations = map[string]configMigration{
+		"v0alpha1": {to: "v0beta1", migrate: func(config map[string]interface{}) error {
+			config["serviceName"] = config["service"]
+			delete(config, "service")
+			return nil
+		}},
+		"v0beta1": {to: ConfigAPIVersion, migrate: func(config map[string]interface{}) error {
+			config["canarySubsetName"] = "canary"
+			return nil
+		}},
+	}
+	t.Cleanup(func() { configMigrations = map[string]configMigration{} })
+
+	testCases := map[string]struct {
+		config         string
+		expectedConfig ConsulTrafficRouting
+		expectedError  string
+	}{
+		"no apiVersion": {
+			config:         `{"serviceName":"test-service"}`,
+			expectedConfig: ConsulTrafficRouting{APIVersion: ConfigAPIVersion, ServiceName: "test-service"},
+		},
+		"current apiVersion": {
+			config:         `{"apiVersion":"v1","serviceName":"test-service"}`,
+			expectedConfig: ConsulTrafficRouting{APIVersion: ConfigAPIVersion, ServiceName: "test-service"},
+		},
+		"migrated through every version": {
+			config:         `{"apiVersion":"v0alpha1","service":"test-service"}`,
+			expectedConfig: ConsulTrafficRouting{APIVersion: ConfigAPIVersion, ServiceName: "test-service", CanarySubsetName: "canary"},
+		},
+		"unsupported apiVersion": {
+			config:        `{"apiVersion":"v2","serviceName":"test-service"}`,
+			expectedError: `invalid consul traffic routing configuration. apiVersion "v2" is not supported, expected one of v0alpha1, v0beta1, v1`,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := ConsulTrafficRouting{}
+			err := decodeConfig([]byte(testCase.config), &cfg)
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+				require.Equal(t, testCase.expectedConfig, cfg)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+			}
+		})
+	}
+}
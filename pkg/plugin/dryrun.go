@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EventReasonDryRun is the reason of the events describing the changes a dry run did not write
+const EventReasonDryRun = "ConsulDryRun"
+
+// dryRunChange is a config entry as read from the cluster and as the plugin would write it
+type dryRunChange struct {
+	kind     string
+	original client.Object
+	desired  client.Object
+}
+
+// dryRunEnabled reports whether the plugin settings or the rollout configuration ask for a dry run
+func dryRunEnabled(cfg *ConsulTrafficRouting, settings *PluginSettings) bool {
+	return cfg.DryRun || (settings != nil && settings.DryRun)
+}
+
+// reportDryRun logs and records an event with the JSON merge patch of every change that a dry run does not write
+func (r *RpcPlugin) reportDryRun(rollout *v1alpha1.Rollout, changes []dryRunChange) error {
+	for _, change := range changes {
+		patch, err := mergePatch(change.original, change.desired)
+		if err != nil {
+			return err
+		}
+		if patch == "" {
+			continue
+		}
+		r.LogCtx.WithFields(logrus.Fields{"kind": change.kind, "name": change.desired.GetName(), "patch": patch}).Info("Dry run, not updating")
+		r.recordEvent(rollout, corev1.EventTypeNormal, EventReasonDryRun, "Dry run: %s %s would be patched with %s", change.kind, change.desired.GetName(), patch)
+	}
+	return nil
+}
+
+// mergePatch returns the JSON merge patch turning original into desired, or an empty string if they are equal
+func mergePatch(original, desired client.Object) (string, error) {
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return "", err
+	}
+	desiredJSON, err := json.Marshal(desired)
+	if err != nil {
+		return "", err
+	}
+	patch, err := jsonpatch.CreateMergePatch(originalJSON, desiredJSON)
+	if err != nil {
+		return "", err
+	}
+	if string(patch) == "{}" {
+		return "", nil
+	}
+	return string(patch), nil
+}
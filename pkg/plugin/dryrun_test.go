@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetWeightDryRun(t *testing.T) {
+	dryRunConfig, err := json.Marshal(ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		DryRun:           true,
+	})
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		pluginConfig []byte
+		settings     *PluginSettings
+	}{
+		"dry run in the rollout":              {pluginConfig: dryRunConfig},
+		"dry run in the settings":             {pluginConfig: pluginJson(), settings: &PluginSettings{DryRun: true}},
+		"dry run in the rollout and settings": {pluginConfig: dryRunConfig, settings: &PluginSettings{DryRun: true}},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+			recorder := record.NewFakeRecorder(10)
+			p := &RpcPlugin{
+				K8SClient: k8sClient,
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+				Recorder:  recorder,
+				Settings:  testCase.settings,
+			}
+			rollout := newTestRollout(testCase.pluginConfig, corev1.ConditionFalse, 30)
+			rpcErr := p.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+			require.Empty(t, rpcErr.ErrorString)
+
+			namespacedName := types.NamespacedName{Name: "test-service", Namespace: "default"}
+			actualSplitter := &consulv1aplha1.ServiceSplitter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+			require.Equal(t, defaultSplitter().Spec, actualSplitter.Spec)
+			require.Empty(t, actualSplitter.Annotations)
+			actualResolver := &consulv1aplha1.ServiceResolver{}
+			require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualResolver, &client.GetOptions{}))
+			require.Equal(t, defaultResolver().Spec, actualResolver.Spec)
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			require.Len(t, events, 2)
+			require.Contains(t, events[0], `Normal ConsulDryRun Dry run: ServiceSplitter test-service would be patched with`)
+			require.Contains(t, events[0], `"splits":[{"serviceSubset":"stable","weight":70},{"serviceSubset":"canary","weight":30}]`)
+			require.Contains(t, events[1], `Normal ConsulDryRun Dry run: ServiceResolver test-service would be patched with`)
+			require.Contains(t, events[1], `"canary":{"filter":"Service.Meta.version == \"2\""}`)
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	patch, err := mergePatch(defaultSplitter(), defaultSplitter())
+	require.NoError(t, err)
+	require.Empty(t, patch)
+
+	desired := defaultSplitter()
+	desired.Spec.Splits[1].Weight = 10
+	patch, err = mergePatch(defaultSplitter(), desired)
+	require.NoError(t, err)
+	require.Equal(t, `{"spec":{"splits":[{"serviceSubset":"stable","weight":100},{"serviceSubset":"canary","weight":10}]}}`, patch)
+}
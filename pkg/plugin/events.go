@@ -0,0 +1,88 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the events recorded on the Rollout
+const (
+	EventReasonWeightUpdated   = "ConsulWeightUpdated"
+	EventReasonResolverUpdated = "ConsulResolverUpdated"
+	EventReasonSyncPending     = "ConsulSyncPending"
+)
+
+// eventComponent is the source of the events recorded by the plugin
+const eventComponent = "rollouts-plugin-trafficrouter-consul"
+
+// newEventRecorder returns a recorder writing events through the Kubernetes API
+func newEventRecorder(cfg *rest.Config, s *runtime.Scheme) (record.EventRecorder, error) {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(s, corev1.EventSource{Component: eventComponent}), nil
+}
+
+// recordEvent records an event on the rollout, if the plugin has an event recorder
+func (r *RpcPlugin) recordEvent(rollout *v1alpha1.Rollout, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(rollout, eventType, reason, messageFmt, args...)
+}
+
+// recordSplitterEvent records the change of the subset weights of the splitter
+func (r *RpcPlugin) recordSplitterEvent(rollout *v1alpha1.Rollout, before, after *consulv1aplha1.ServiceSplitter, canarySubsetName, stableSubsetName string) {
+	oldCanary, oldStable := splitWeights(before, canarySubsetName, stableSubsetName)
+	newCanary, newStable := splitWeights(after, canarySubsetName, stableSubsetName)
+	if oldCanary == newCanary && oldStable == newStable {
+		return
+	}
+	r.recordEvent(rollout, corev1.EventTypeNormal, EventReasonWeightUpdated,
+		"Service splitter %s weights updated from %s=%v, %s=%v to %s=%v, %s=%v", after.GetName(),
+		canarySubsetName, oldCanary, stableSubsetName, oldStable, canarySubsetName, newCanary, stableSubsetName, newStable)
+}
+
+// recordResolverEvent records the change of the subset filters of the resolver
+func (r *RpcPlugin) recordResolverEvent(rollout *v1alpha1.Rollout, before, after *consulv1aplha1.ServiceResolver, canarySubsetName, stableSubsetName string) {
+	if reflect.DeepEqual(before.Spec.Subsets, after.Spec.Subsets) {
+		return
+	}
+	r.recordEvent(rollout, corev1.EventTypeNormal, EventReasonResolverUpdated,
+		"Service resolver %s filters updated from %s=%q, %s=%q to %s=%q, %s=%q", after.GetName(),
+		canarySubsetName, before.Spec.Subsets[canarySubsetName].Filter, stableSubsetName, before.Spec.Subsets[stableSubsetName].Filter,
+		canarySubsetName, after.Spec.Subsets[canarySubsetName].Filter, stableSubsetName, after.Spec.Subsets[stableSubsetName].Filter)
+}
+
+// recordSyncPendingEvent records that a config entry has not synced with Consul yet
+func (r *RpcPlugin) recordSyncPendingEvent(rollout *v1alpha1.Rollout, kind, name string) {
+	r.recordEvent(rollout, corev1.EventTypeWarning, EventReasonSyncPending, "%s %s has not synced with Consul yet", kind, name)
+}
+
+func splitWeights(splitter *consulv1aplha1.ServiceSplitter, canarySubsetName, stableSubsetName string) (string, string) {
+	canary, stable := "", ""
+	for _, split := range splitter.Spec.Splits {
+		switch split.ServiceSubset {
+		case canarySubsetName:
+			canary = fmt.Sprint(split.Weight)
+		case stableSubsetName:
+			stable = fmt.Sprint(split.Weight)
+		}
+	}
+	return canary, stable
+}
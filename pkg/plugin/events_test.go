@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetWeightEvents(t *testing.T) {
+	unsyncedSplitter := defaultSplitter()
+	unsyncedSplitter.Status.Conditions[0].Status = corev1.ConditionFalse
+
+	testCases := map[string]struct {
+		inputSplitter  *consulv1aplha1.ServiceSplitter
+		desiredWeight  int32
+		expectedEvents []string
+	}{
+		"weight and filter updated": {
+			inputSplitter: defaultSplitter(),
+			desiredWeight: 40,
+			expectedEvents: []string{
+				"Normal ConsulWeightUpdated Service splitter test-service weights updated from canary=0, stable=100 to canary=40, stable=60",
+				`Normal ConsulResolverUpdated Service resolver test-service filters updated from canary="", stable="Service.Meta.version == 1" to canary="Service.Meta.version == \"2\"", stable="Service.Meta.version == 1"`,
+			},
+		},
+		"splitter not synced": {
+			inputSplitter: unsyncedSplitter,
+			desiredWeight: 40,
+			expectedEvents: []string{
+				"Warning ConsulSyncPending ServiceSplitter test-service has not synced with Consul yet",
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			recorder := record.NewFakeRecorder(10)
+			p := &RpcPlugin{
+				K8SClient: fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), testCase.inputSplitter).Build(),
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+				Recorder:  recorder,
+			}
+			rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, testCase.desiredWeight)
+			p.SetWeight(rollout, testCase.desiredWeight, []v1alpha1.WeightDestination{})
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			require.Equal(t, testCase.expectedEvents, events)
+		})
+	}
+}
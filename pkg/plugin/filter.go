@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/hashicorp/go-bexpr"
+)
+
+const (
+	serviceMetaVersionAnnotation = "consul.hashicorp.com/service-meta-%s"
+	defaultServiceMetaSuffix     = "version"
+)
+
+// idleCanaryFilter is the canary subset filter while no canary is in progress. It selects on a reserved meta key that
+// is never set, so the canary subset matches no instances. An empty filter would match every instance instead.
+const idleCanaryFilter = `Service.Meta.argo_rollouts_idle_canary == "true"`
+
+// canaryFilterIdle reports whether the canary subset filter does not select a canary version
+func canaryFilterIdle(filter string) bool {
+	return filter == idleCanaryFilter || strings.TrimSpace(filter) == ""
+}
+
+// bexprIdentifier matches the keys that can be used as a bexpr selector without index syntax
+var bexprIdentifier = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// buildServiceMetaFilter returns a filter selecting the service instances whose meta key equals value. The value is
+// always quoted, and the resulting expression is validated before it is returned.
+func buildServiceMetaFilter(key, value string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("service meta key must not be empty")
+	}
+	selector := "Service.Meta." + key
+	if !bexprIdentifier.MatchString(key) {
+		quotedKey, err := quoteFilterString(key)
+		if err != nil {
+			return "", fmt.Errorf("service meta key %q: %w", key, err)
+		}
+		selector = fmt.Sprintf("Service.Meta[%s]", quotedKey)
+	}
+	quotedValue, err := quoteFilterString(value)
+	if err != nil {
+		return "", fmt.Errorf("service meta value %q: %w", value, err)
+	}
+	filter := fmt.Sprintf("%s == %s", selector, quotedValue)
+	if err := validateFilter(filter); err != nil {
+		return "", err
+	}
+	return filter, nil
+}
+
+// quoteFilterString returns s as a bexpr string literal. bexpr does not support escaped double quotes, so values
+// containing a double quote are written as raw strings instead.
+func quoteFilterString(s string) (string, error) {
+	if !strings.Contains(s, `"`) {
+		return strconv.Quote(s), nil
+	}
+	if !strings.Contains(s, "`") {
+		return "`" + s + "`", nil
+	}
+	return "", fmt.Errorf("values containing both \" and ` cannot be used in a filter")
+}
+
+// validateFilter checks that filter is a valid bexpr expression
+func validateFilter(filter string) error {
+	if _, err := bexpr.CreateEvaluator(filter); err != nil {
+		return fmt.Errorf("invalid filter %q: %w", filter, err)
+	}
+	return nil
+}
+
+// subsetFilterValues are the values available to the subset filter template
+type subsetFilterValues struct {
+	Annotations   map[string]string
+	Labels        map[string]string
+	Rollout       string
+	Namespace     string
+	Subset        string
+	PodHash       string
+	CanaryPodHash string
+	StablePodHash string
+}
+
+var subsetFilterFuncs = template.FuncMap{
+	"quote": quoteFilterString,
+}
+
+// subsetFilter returns the filter selecting the pods of the current pod template for the subset, rendered from the
+// configured template or built from the service meta version annotation
+func subsetFilter(cfg *ConsulTrafficRouting, rollout *v1alpha1.Rollout, subsetName string) (string, error) {
+	annotations := rollout.Spec.Template.GetObjectMeta().GetAnnotations()
+	if cfg.SubsetFilterTemplate != "" {
+		return renderSubsetFilter(cfg.SubsetFilterTemplate, subsetFilterValues{
+			Annotations:   annotations,
+			Labels:        rollout.Spec.Template.GetObjectMeta().GetLabels(),
+			Rollout:       rollout.GetName(),
+			Namespace:     rollout.GetNamespace(),
+			Subset:        subsetName,
+			PodHash:       rollout.Status.CurrentPodHash,
+			CanaryPodHash: rollout.Status.CurrentPodHash,
+			StablePodHash: rollout.Status.StableRS,
+		})
+	}
+
+	suffix := defaultServiceMetaSuffix
+	if cfg.ServiceMetaAnnotationSuffix != "" {
+		suffix = cfg.ServiceMetaAnnotationSuffix
+	}
+	annotation := fmt.Sprintf(serviceMetaVersionAnnotation, suffix)
+	version := annotations[annotation]
+	if version == "" {
+		return "", fmt.Errorf("annotation %s is missing or empty on the pod template of rollout %s/%s. It is required to select the %s version in the service resolver",
+			annotation, rollout.GetNamespace(), rollout.GetName(), subsetName)
+	}
+	return buildServiceMetaFilter(suffix, version)
+}
+
+// renderSubsetFilter renders the subset filter template and validates the result
+func renderSubsetFilter(text string, values subsetFilterValues) (string, error) {
+	tmpl, err := template.New("subsetFilterTemplate").Funcs(subsetFilterFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid subsetFilterTemplate: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, values); err != nil {
+		return "", fmt.Errorf("subsetFilterTemplate could not be rendered for subset %s: %w", values.Subset, err)
+	}
+	filter := strings.TrimSpace(b.String())
+	if filter == "" {
+		return "", fmt.Errorf("subsetFilterTemplate rendered an empty filter for subset %s", values.Subset)
+	}
+	if err := validateFilter(filter); err != nil {
+		return "", err
+	}
+	return filter, nil
+}
+
+func validateSubsetFilterTemplate(text string) error {
+	if text == "" {
+		return nil
+	}
+	if _, err := template.New("subsetFilterTemplate").Funcs(subsetFilterFuncs).Parse(text); err != nil {
+		return errors.New("invalid consul traffic routing configuration. subsetFilterTemplate: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildServiceMetaFilter(t *testing.T) {
+	testCases := []struct {
+		testName       string
+		key            string
+		value          string
+		expectedFilter string
+		expectedError  string
+	}{
+		{
+			testName:       "simple value",
+			key:            "version",
+			value:          "2",
+			expectedFilter: `Service.Meta.version == "2"`,
+		},
+		{
+			testName:       "pre-release version",
+			key:            "version",
+			value:          "1.2.0-rc1",
+			expectedFilter: `Service.Meta.version == "1.2.0-rc1"`,
+		},
+		{
+			testName:       "value with spaces and backslash",
+			key:            "version",
+			value:          `v2 build\7`,
+			expectedFilter: `Service.Meta.version == "v2 build\\7"`,
+		},
+		{
+			testName:       "value with double quote",
+			key:            "version",
+			value:          `v"2`,
+			expectedFilter: "Service.Meta.version == `v\"2`",
+		},
+		{
+			testName:       "key requiring index syntax",
+			key:            "app-version",
+			value:          "2",
+			expectedFilter: `Service.Meta["app-version"] == "2"`,
+		},
+		{
+			testName:      "value with double quote and backtick",
+			key:           "version",
+			value:         "v\"`2",
+			expectedError: "cannot be used in a filter",
+		},
+		{
+			testName:      "empty key",
+			key:           "",
+			value:         "2",
+			expectedError: "service meta key must not be empty",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			filter, err := buildServiceMetaFilter(testCase.key, testCase.value)
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedFilter, filter)
+		})
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	require.NoError(t, validateFilter(`Service.Meta.version == "2"`))
+	require.Error(t, validateFilter(`Service.Meta.version == `))
+}
+
+func TestSubsetFilterTemplate(t *testing.T) {
+	rollout := &v1alpha1.Rollout{
+		ObjectMeta: metav1.ObjectMeta{Name: "rollout", Namespace: "default"},
+		Spec: v1alpha1.RolloutSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"consul.hashicorp.com/service-tags": "v2"},
+					Labels:      map[string]string{"track": "blue"},
+				},
+			},
+		},
+		Status: v1alpha1.RolloutStatus{CurrentPodHash: "abc123", StableRS: "def456"},
+	}
+	testCases := []struct {
+		testName       string
+		template       string
+		expectedFilter string
+		expectedError  string
+	}{
+		{
+			testName:       "service tags",
+			template:       `{{ quote (index .Annotations "consul.hashicorp.com/service-tags") }} in Service.Tags`,
+			expectedFilter: `"v2" in Service.Tags`,
+		},
+		{
+			testName:       "combination of meta keys",
+			template:       `Service.Meta.track == {{ quote (index .Labels "track") }} and Service.Meta.hash == {{ quote .PodHash }} and Service.Meta.rollout == {{ quote .Rollout }}`,
+			expectedFilter: `Service.Meta.track == "blue" and Service.Meta.hash == "abc123" and Service.Meta.rollout == "rollout"`,
+		},
+		{
+			testName:      "empty result",
+			template:      `{{ index .Annotations "missing" }}`,
+			expectedError: "subsetFilterTemplate rendered an empty filter for subset canary",
+		},
+		{
+			testName:      "invalid filter",
+			template:      `Service.Meta.version == `,
+			expectedError: "invalid filter",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			cfg := &ConsulTrafficRouting{SubsetFilterTemplate: testCase.template}
+			filter, err := subsetFilter(cfg, rollout, "canary")
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedFilter, filter)
+		})
+	}
+}
+
+func TestValidateSubsetFilterTemplate(t *testing.T) {
+	require.NoError(t, validateSubsetFilterTemplate(""))
+	require.NoError(t, validateSubsetFilterTemplate(`{{ quote .PodHash }} in Service.Tags`))
+	require.ErrorContains(t, validateSubsetFilterTemplate(`{{ .PodHash `), "subsetFilterTemplate")
+}
+
+func TestCanaryFilterIdle(t *testing.T) {
+	require.True(t, canaryFilterIdle(idleCanaryFilter))
+	require.True(t, canaryFilterIdle(""))
+	require.True(t, canaryFilterIdle("  "))
+	require.False(t, canaryFilterIdle(`Service.Meta.version == "2"`))
+	require.NoError(t, validateFilter(idleCanaryFilter))
+}
@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+)
+
+// revisionAnnotation is set by the Argo Rollouts controller to the revision of the current pod template
+const revisionAnnotation = "rollout.argoproj.io/revision"
+
+// SplitHeaders are the request and response header modifiers applied to a split of the service splitter.
+// Header values are Go templates with access to .Rollout, .Revision, .PodHash and .Subset, for example
+// `x-rollout-revision: "{{ .Revision }}"`.
+type SplitHeaders struct {
+	RequestHeaders  *consulv1aplha1.HTTPHeaderModifiers `json:"requestHeaders,omitempty" protobuf:"bytes,1,opt,name=requestHeaders"`
+	ResponseHeaders *consulv1aplha1.HTTPHeaderModifiers `json:"responseHeaders,omitempty" protobuf:"bytes,2,opt,name=responseHeaders"`
+}
+
+// splitHeaderValues are the values available to the header value templates
+type splitHeaderValues struct {
+	Rollout  string
+	Revision string
+	PodHash  string
+	Subset   string
+}
+
+func newSplitHeaderValues(rollout *v1alpha1.Rollout, subsetName, podHash string) splitHeaderValues {
+	return splitHeaderValues{
+		Rollout:  rollout.GetName(),
+		Revision: rollout.GetAnnotations()[revisionAnnotation],
+		PodHash:  podHash,
+		Subset:   subsetName,
+	}
+}
+
+func validateSplitHeaders(field string, h *SplitHeaders) error {
+	if h == nil {
+		return nil
+	}
+	if _, err := renderHeaderModifiers(h.RequestHeaders, splitHeaderValues{}); err != nil {
+		return fmt.Errorf("invalid consul traffic routing configuration. %s.requestHeaders: %w", field, err)
+	}
+	if _, err := renderHeaderModifiers(h.ResponseHeaders, splitHeaderValues{}); err != nil {
+		return fmt.Errorf("invalid consul traffic routing configuration. %s.responseHeaders: %w", field, err)
+	}
+	return nil
+}
+
+// setSplitHeaders replaces the header modifiers of the split with the rendered configuration, adding extraResponse
+// to the response headers
+func setSplitHeaders(split *consulv1aplha1.ServiceSplit, h *SplitHeaders, extraResponse *consulv1aplha1.HTTPHeaderModifiers, values splitHeaderValues) error {
+	var request, response *consulv1aplha1.HTTPHeaderModifiers
+	if h != nil {
+		var err error
+		if request, err = renderHeaderModifiers(h.RequestHeaders, values); err != nil {
+			return err
+		}
+		if response, err = renderHeaderModifiers(h.ResponseHeaders, values); err != nil {
+			return err
+		}
+	}
+	split.RequestHeaders = request
+	split.ResponseHeaders = mergeHeaderModifiers(response, extraResponse)
+	return nil
+}
+
+// renderHeaderModifiers returns a copy of m with every Add and Set value rendered as a template
+func renderHeaderModifiers(m *consulv1aplha1.HTTPHeaderModifiers, values splitHeaderValues) (*consulv1aplha1.HTTPHeaderModifiers, error) {
+	if m == nil {
+		return nil, nil
+	}
+	rendered := m.DeepCopy()
+	for _, headers := range []map[string]string{rendered.Add, rendered.Set} {
+		for name, value := range headers {
+			tmpl, err := template.New(name).Option("missingkey=error").Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", name, err)
+			}
+			var b strings.Builder
+			if err := tmpl.Execute(&b, values); err != nil {
+				return nil, fmt.Errorf("header %s: %w", name, err)
+			}
+			headers[name] = b.String()
+		}
+	}
+	return rendered, nil
+}
+
+// mergeHeaderModifiers combines two sets of header modifiers, with b taking precedence on conflicting names
+func mergeHeaderModifiers(a, b *consulv1aplha1.HTTPHeaderModifiers) *consulv1aplha1.HTTPHeaderModifiers {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	merged := a.DeepCopy()
+	if len(b.Add) > 0 && merged.Add == nil {
+		merged.Add = map[string]string{}
+	}
+	for name, value := range b.Add {
+		merged.Add[name] = value
+	}
+	if len(b.Set) > 0 && merged.Set == nil {
+		merged.Set = map[string]string{}
+	}
+	for name, value := range b.Set {
+		merged.Set[name] = value
+	}
+	merged.Remove = append(merged.Remove, b.Remove...)
+	return merged
+}
@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// lockAnnotation names the rollout that currently owns the service splitter and resolver of a service
+	lockAnnotation = "argo-rollouts.argoproj.io/consul-lock"
+
+	// takeLockAnnotation can be set to "true" on a Rollout to take the lock from another rollout that still holds it
+	takeLockAnnotation = "argo-rollouts.argoproj.io/consul-take-lock"
+)
+
+type rolloutLock struct {
+	Rollout string    `json:"rollout"`
+	UID     types.UID `json:"uid"`
+}
+
+// acquireLock sets the lock on the splitter to the rollout. It fails when another rollout that still exists holds the
+// lock, unless the rollout is annotated to take it. The lock is written together with the splitter, so two rollouts
+// acquiring it at once conflict on the update of the splitter.
+func (r *RpcPlugin) acquireLock(ctx context.Context, rollout *v1alpha1.Rollout, splitter *consulv1aplha1.ServiceSplitter) error {
+	owner, err := readLock(splitter)
+	if err != nil {
+		return err
+	}
+	if owner != nil && !ownsLock(owner, rollout) && rollout.GetAnnotations()[takeLockAnnotation] != "true" {
+		held, err := r.lockOwnerExists(ctx, rollout.GetNamespace(), owner)
+		if err != nil {
+			return err
+		}
+		if held {
+			return fmt.Errorf("service splitter %s is locked by rollout %s, which has not completed or aborted yet. Set the annotation %s: \"true\" on rollout %s to take the lock",
+				splitter.GetName(), owner.Rollout, takeLockAnnotation, rollout.GetName())
+		}
+		r.LogCtx.WithField("owner", owner.Rollout).Info("Taking the lock of a rollout that no longer exists")
+	}
+
+	encoded, err := json.Marshal(rolloutLock{Rollout: rollout.GetName(), UID: rollout.GetUID()})
+	if err != nil {
+		return err
+	}
+	annotations := splitter.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[lockAnnotation] = string(encoded)
+	splitter.SetAnnotations(annotations)
+	return nil
+}
+
+// releaseLock removes the lock from the splitter when it is held by the rollout
+func releaseLock(rollout *v1alpha1.Rollout, splitter *consulv1aplha1.ServiceSplitter) error {
+	owner, err := readLock(splitter)
+	if err != nil || owner == nil || !ownsLock(owner, rollout) {
+		return err
+	}
+	annotations := splitter.GetAnnotations()
+	delete(annotations, lockAnnotation)
+	splitter.SetAnnotations(annotations)
+	return nil
+}
+
+// lockOwnerExists reports whether the rollout holding the lock still exists. A rollout that was deleted and recreated
+// under the same name does not own the lock.
+func (r *RpcPlugin) lockOwnerExists(ctx context.Context, namespace string, owner *rolloutLock) (bool, error) {
+	existing := &v1alpha1.Rollout{}
+	err := r.rolloutReader().Get(ctx, types.NamespacedName{Name: owner.Rollout, Namespace: namespace}, existing, &client.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("owner of the lock could not be read: %w", err)
+	}
+	return owner.UID == "" || existing.GetUID() == owner.UID, nil
+}
+
+// rolloutReader returns the reader of the Rollouts in the cluster of the rollouts controller
+func (r *RpcPlugin) rolloutReader() client.Reader {
+	if r.RolloutReader != nil {
+		return r.RolloutReader
+	}
+	return r.K8SClient
+}
+
+func ownsLock(owner *rolloutLock, rollout *v1alpha1.Rollout) bool {
+	return owner.Rollout == rollout.GetName() && (owner.UID == "" || owner.UID == rollout.GetUID())
+}
+
+func readLock(splitter *consulv1aplha1.ServiceSplitter) (*rolloutLock, error) {
+	encoded, ok := splitter.GetAnnotations()[lockAnnotation]
+	if !ok || encoded == "" {
+		return nil, nil
+	}
+	owner := &rolloutLock{}
+	if err := json.Unmarshal([]byte(encoded), owner); err != nil {
+		return nil, errors.New("annotation " + lockAnnotation + " could not be parsed: " + err.Error())
+	}
+	return owner, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetWeightLock(t *testing.T) {
+	otherRollout := &v1alpha1.Rollout{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "other-uid"},
+	}
+	lockedBy := func(name, uid string) *consulv1aplha1.ServiceSplitter {
+		splitter := defaultSplitter()
+		splitter.Annotations = map[string]string{lockAnnotation: `{"rollout":"` + name + `","uid":"` + uid + `"}`}
+		return splitter
+	}
+
+	testCases := map[string]struct {
+		inputSplitter   *consulv1aplha1.ServiceSplitter
+		takeLock        bool
+		completed       corev1.ConditionStatus
+		expectedError   string
+		expectedLock    string
+		expectedWeights []float32
+	}{
+		"unlocked splitter is locked by the rollout": {
+			inputSplitter:   defaultSplitter(),
+			completed:       corev1.ConditionFalse,
+			expectedLock:    `{"rollout":"rollout","uid":"rollout-uid"}`,
+			expectedWeights: []float32{60, 40},
+		},
+		"splitter locked by an existing rollout": {
+			inputSplitter:   lockedBy("other", "other-uid"),
+			completed:       corev1.ConditionFalse,
+			expectedError:   "service splitter test-service is locked by rollout other, which has not completed or aborted yet. Set the annotation argo-rollouts.argoproj.io/consul-take-lock: \"true\" on rollout rollout to take the lock",
+			expectedLock:    `{"rollout":"other","uid":"other-uid"}`,
+			expectedWeights: []float32{100, 0},
+		},
+		"splitter locked by a deleted rollout": {
+			inputSplitter:   lockedBy("deleted", "deleted-uid"),
+			completed:       corev1.ConditionFalse,
+			expectedLock:    `{"rollout":"rollout","uid":"rollout-uid"}`,
+			expectedWeights: []float32{60, 40},
+		},
+		"splitter locked by a rollout that was recreated": {
+			inputSplitter:   lockedBy("other", "old-uid"),
+			completed:       corev1.ConditionFalse,
+			expectedLock:    `{"rollout":"rollout","uid":"rollout-uid"}`,
+			expectedWeights: []float32{60, 40},
+		},
+		"lock taken from an existing rollout": {
+			inputSplitter:   lockedBy("other", "other-uid"),
+			takeLock:        true,
+			completed:       corev1.ConditionFalse,
+			expectedLock:    `{"rollout":"rollout","uid":"rollout-uid"}`,
+			expectedWeights: []float32{60, 40},
+		},
+		"lock released on completion": {
+			inputSplitter:   lockedBy("rollout", "rollout-uid"),
+			completed:       corev1.ConditionTrue,
+			expectedWeights: []float32{100, 0},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			require.NoError(t, v1alpha1.AddToScheme(s))
+			k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), testCase.inputSplitter, otherRollout).Build()
+			p := &RpcPlugin{
+				K8SClient: k8sClient,
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+
+			weight := int32(40)
+			if testCase.completed == corev1.ConditionTrue {
+				weight = 0
+			}
+			rollout := newTestRollout(pluginJson(), testCase.completed, weight)
+			rollout.UID = "rollout-uid"
+			if testCase.takeLock {
+				rollout.Annotations = map[string]string{takeLockAnnotation: "true"}
+			}
+			rpcErr := p.SetWeight(rollout, weight, []v1alpha1.WeightDestination{})
+			require.Equal(t, testCase.expectedError, rpcErr.ErrorString)
+
+			actualSplitter := &consulv1aplha1.ServiceSplitter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualSplitter, &client.GetOptions{}))
+			require.Equal(t, testCase.expectedLock, actualSplitter.Annotations[lockAnnotation])
+			require.Equal(t, testCase.expectedWeights, []float32{actualSplitter.Spec.Splits[0].Weight, actualSplitter.Spec.Splits[1].Weight})
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"net/http"
+	"time"
+
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "rollouts_plugin_consul"
+
+// Metrics are the Prometheus metrics of the plugin operations. A nil *Metrics records nothing.
+type Metrics struct {
+	rpcRequests            *prometheus.CounterVec
+	setWeightDuration      prometheus.Histogram
+	canaryWeight           *prometheus.GaugeVec
+	syncValidationFailures *prometheus.CounterVec
+}
+
+// NewMetrics creates the plugin metrics and registers them with registerer
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		rpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rpc_requests_total",
+			Help:      "Number of plugin RPC calls, by method and result.",
+		}, []string{"method", "result"}),
+		setWeightDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "set_weight_duration_seconds",
+			Help:      "Duration of SetWeight calls.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		canaryWeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "canary_weight",
+			Help:      "Weight of the canary subset in the service splitter.",
+		}, []string{"namespace", "service"}),
+		syncValidationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sync_validation_failures_total",
+			Help:      "Number of times a config entry had not synced with Consul, by kind.",
+		}, []string{"namespace", "service", "kind"}),
+	}
+	for _, c := range []prometheus.Collector{m.rpcRequests, m.setWeightDuration, m.canaryWeight, m.syncValidationFailures} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// MetricsHandler serves the metrics of gatherer on /metrics
+func MetricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// observeRPC counts a call of method by its result, and records the duration of SetWeight calls
+func (m *Metrics) observeRPC(method string, start time.Time, rpcErr *pluginTypes.RpcError) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if rpcErr.HasError() {
+		result = "error"
+	}
+	m.rpcRequests.WithLabelValues(method, result).Inc()
+	if method == "SetWeight" {
+		m.setWeightDuration.Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *Metrics) setCanaryWeight(namespace, service string, weight float32) {
+	if m == nil {
+		return
+	}
+	m.canaryWeight.WithLabelValues(namespace, service).Set(float64(weight))
+}
+
+func (m *Metrics) syncValidationFailed(namespace, service, kind string) {
+	if m == nil {
+		return
+	}
+	m.syncValidationFailures.WithLabelValues(namespace, service, kind).Inc()
+}
@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry)
+	require.NoError(t, err)
+	server := httptest.NewServer(MetricsHandler(registry))
+	defer server.Close()
+
+	unsyncedResolver := defaultResolver()
+	unsyncedResolver.Status.Conditions[0].Status = corev1.ConditionFalse
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	p := &RpcPlugin{
+		K8SClient: fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build(),
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+		Metrics:   metrics,
+	}
+	rpcErr := p.InitPlugin()
+	require.Empty(t, rpcErr.ErrorString)
+	rpcErr = p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 30), 30, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 30)
+	require.Empty(t, p.UpdateHash(rollout, "canary", "stable", nil).ErrorString)
+	require.Empty(t, p.SetHeaderRoute(rollout, &v1alpha1.SetHeaderRoute{}).ErrorString)
+	_, rpcErr = p.VerifyWeight(rollout, 30, nil)
+	require.Empty(t, rpcErr.ErrorString)
+	require.Equal(t, Type, p.Type())
+
+	p.K8SClient = fake.NewClientBuilder().WithScheme(s).WithObjects(unsyncedResolver, defaultSplitter()).Build()
+	rpcErr = p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 50), 50, []v1alpha1.WeightDestination{})
+	require.NotEmpty(t, rpcErr.ErrorString)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	require.Contains(t, string(body), `rollouts_plugin_consul_rpc_requests_total{method="SetWeight",result="success"} 1`)
+	require.Contains(t, string(body), `rollouts_plugin_consul_rpc_requests_total{method="SetWeight",result="error"} 1`)
+	for _, method := range []string{"InitPlugin", "UpdateHash", "SetHeaderRoute", "VerifyWeight", "Type"} {
+		require.Contains(t, string(body), `rollouts_plugin_consul_rpc_requests_total{method="`+method+`",result="success"} 1`)
+	}
+	require.Contains(t, string(body), `rollouts_plugin_consul_set_weight_duration_seconds_count 2`)
+	require.Contains(t, string(body), `rollouts_plugin_consul_canary_weight{namespace="default",service="test-service"} 30`)
+	require.Contains(t, string(body), `rollouts_plugin_consul_sync_validation_failures_total{kind="ServiceResolver",namespace="default",service="test-service"} 1`)
+}
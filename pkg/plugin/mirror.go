@@ -0,0 +1,231 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// managedExtensionsAnnotation records the Envoy extensions on a ServiceDefaults that were written by the plugin,
+	// keyed by the name of the mirror route
+	managedExtensionsAnnotation = "argo-rollouts.argoproj.io/consul-managed-envoy-extensions"
+
+	propertyOverrideExtension = "builtin/property-override"
+)
+
+// Mirror configures traffic mirroring for the SetMirrorRoute step. The mirror is added to the outbound route
+// configuration of the source services for the rollout service, so it applies to every request they send to it.
+type Mirror struct {
+	// SourceServices are the downstream services whose proxies mirror requests to the canary
+	SourceServices []string `json:"sourceServices" protobuf:"bytes,1,rep,name=sourceServices"`
+	// CanaryCluster is the Envoy cluster of the canary subset as seen by the source services,
+	// for example canary.test-service.default.dc1.internal.<trust-domain>.consul
+	CanaryCluster string `json:"canaryCluster" protobuf:"bytes,2,opt,name=canaryCluster"`
+}
+
+func validateMirror(m *Mirror) error {
+	if m == nil {
+		return nil
+	}
+	if len(m.SourceServices) == 0 || m.CanaryCluster == "" {
+		return errors.New("invalid consul traffic routing configuration. mirror.sourceServices and mirror.canaryCluster must be set")
+	}
+	return nil
+}
+
+// validateMirrorMatch checks that the match of a mirror route can be honored. Consul patches the whole route
+// configuration of the service, so only matches selecting every request are supported.
+func validateMirrorMatch(matches []v1alpha1.RouteMatch) error {
+	for _, m := range matches {
+		catchAll := m.Method == nil && len(m.Headers) == 0 &&
+			(m.Path == nil || *m.Path == v1alpha1.StringMatch{Prefix: "/"})
+		if !catchAll {
+			return errors.New("consul mirror routes apply to every request to the service, only an empty match or a path prefix of / is supported")
+		}
+	}
+	return nil
+}
+
+// mirrorExtension returns the property-override extension adding a request mirror policy for the canary cluster to
+// the outbound route configuration of serviceName
+func mirrorExtension(serviceName, canaryCluster string, percentage int32) (consulv1aplha1.EnvoyExtension, error) {
+	arguments := map[string]interface{}{
+		"ProxyType": "connect-proxy",
+		"Patches": []map[string]interface{}{
+			{
+				"ResourceFilter": map[string]interface{}{
+					"ResourceType":     "route",
+					"TrafficDirection": "outbound",
+					"Services":         []map[string]string{{"Name": serviceName}},
+				},
+				"Op":   "add",
+				"Path": "/request_mirror_policies",
+				"Value": []map[string]interface{}{
+					{
+						"cluster": canaryCluster,
+						"runtime_fraction": map[string]interface{}{
+							"default_value": map[string]interface{}{
+								"numerator":   percentage,
+								"denominator": "HUNDRED",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	encoded, err := json.Marshal(arguments)
+	if err != nil {
+		return consulv1aplha1.EnvoyExtension{}, err
+	}
+	return consulv1aplha1.EnvoyExtension{Name: propertyOverrideExtension, Arguments: encoded}, nil
+}
+
+// reconcileMirrorExtension sets or, when extension is nil, removes the extension of the named mirror route on the
+// ServiceDefaults of every source service
+func (r *RpcPlugin) reconcileMirrorExtension(ctx context.Context, namespace string, sourceServices []string, routeName string, extension *consulv1aplha1.EnvoyExtension) error {
+	for _, source := range sourceServices {
+		if err := r.reconcileServiceDefaultsExtension(ctx, namespace, source, routeName, extension); err != nil {
+			return fmt.Errorf("service defaults %s: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// removeMirrorExtensions removes every extension written by the plugin from the ServiceDefaults of the source services
+func (r *RpcPlugin) removeMirrorExtensions(ctx context.Context, namespace string, sourceServices []string) error {
+	for _, source := range sourceServices {
+		if err := r.reconcileServiceDefaultsExtension(ctx, namespace, source, "", nil); err != nil {
+			return fmt.Errorf("service defaults %s: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// reconcileServiceDefaultsExtension replaces the managed extension of the named route. An empty routeName with a nil
+// extension removes all managed extensions.
+func (r *RpcPlugin) reconcileServiceDefaultsExtension(ctx context.Context, namespace, name, routeName string, extension *consulv1aplha1.EnvoyExtension) error {
+	serviceDefaults := &consulv1aplha1.ServiceDefaults{}
+	err := r.K8SClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, serviceDefaults, &client.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		if extension == nil {
+			return nil
+		}
+		serviceDefaults = &consulv1aplha1.ServiceDefaults{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{createdByPluginAnnotation: "true"},
+			},
+		}
+		if err := setManagedExtensions(serviceDefaults, map[string]consulv1aplha1.EnvoyExtension{routeName: *extension}, nil); err != nil {
+			return err
+		}
+		return r.K8SClient.Create(ctx, serviceDefaults, &client.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	managed, err := managedExtensions(serviceDefaults)
+	if err != nil {
+		return err
+	}
+	unmanaged := consulv1aplha1.EnvoyExtensions{}
+	for _, ext := range serviceDefaults.Spec.EnvoyExtensions {
+		isManaged := false
+		for _, m := range managed {
+			if reflect.DeepEqual(ext, m) {
+				isManaged = true
+				break
+			}
+		}
+		if !isManaged {
+			unmanaged = append(unmanaged, ext)
+		}
+	}
+
+	if routeName == "" {
+		managed = map[string]consulv1aplha1.EnvoyExtension{}
+	} else if extension == nil {
+		delete(managed, routeName)
+	} else {
+		managed[routeName] = *extension
+	}
+
+	if len(managed) == 0 && len(unmanaged) == 0 && serviceDefaults.Annotations[createdByPluginAnnotation] == "true" &&
+		reflect.DeepEqual(serviceDefaults.Spec, consulv1aplha1.ServiceDefaultsSpec{EnvoyExtensions: serviceDefaults.Spec.EnvoyExtensions}) {
+		return r.K8SClient.Delete(ctx, serviceDefaults, &client.DeleteOptions{})
+	}
+
+	before := serviceDefaults.DeepCopy()
+	if err := setManagedExtensions(serviceDefaults, managed, unmanaged); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(before.Spec, serviceDefaults.Spec) && reflect.DeepEqual(before.Annotations, serviceDefaults.Annotations) {
+		return nil
+	}
+	r.LogCtx.WithField("serviceDefaults", serviceDefaults).Debug("Updating ServiceDefaults")
+	return r.K8SClient.Update(ctx, serviceDefaults, &client.UpdateOptions{})
+}
+
+// setManagedExtensions writes the unmanaged extensions followed by the managed extensions, ordered by route name, and
+// records the managed extensions in the annotation
+func setManagedExtensions(sd *consulv1aplha1.ServiceDefaults, managed map[string]consulv1aplha1.EnvoyExtension, unmanaged consulv1aplha1.EnvoyExtensions) error {
+	names := make([]string, 0, len(managed))
+	for name := range managed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	extensions := consulv1aplha1.EnvoyExtensions{}
+	extensions = append(extensions, unmanaged...)
+	for _, name := range names {
+		extensions = append(extensions, managed[name])
+	}
+	if len(extensions) == 0 {
+		extensions = nil
+	}
+	sd.Spec.EnvoyExtensions = extensions
+
+	if sd.Annotations == nil {
+		sd.Annotations = map[string]string{}
+	}
+	if len(managed) == 0 {
+		delete(sd.Annotations, managedExtensionsAnnotation)
+		return nil
+	}
+	encoded, err := json.Marshal(managed)
+	if err != nil {
+		return err
+	}
+	sd.Annotations[managedExtensionsAnnotation] = string(encoded)
+	return nil
+}
+
+// managedExtensions returns the extensions recorded as written by the plugin, keyed by mirror route name
+func managedExtensions(sd *consulv1aplha1.ServiceDefaults) (map[string]consulv1aplha1.EnvoyExtension, error) {
+	managed := map[string]consulv1aplha1.EnvoyExtension{}
+	encoded, ok := sd.Annotations[managedExtensionsAnnotation]
+	if !ok || encoded == "" {
+		return managed, nil
+	}
+	if err := json.Unmarshal([]byte(encoded), &managed); err != nil {
+		return nil, errors.New("annotation " + managedExtensionsAnnotation + " on service defaults could not be parsed: " + err.Error())
+	}
+	return managed, nil
+}
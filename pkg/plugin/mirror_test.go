@@ -0,0 +1,132 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetMirrorRoute(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	existingExtension := consulv1aplha1.EnvoyExtension{Name: "builtin/lua", Arguments: json.RawMessage(`{"Listener":"inbound"}`)}
+	frontendDefaults := &consulv1aplha1.ServiceDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+		Spec: consulv1aplha1.ServiceDefaultsSpec{
+			Protocol:        "http",
+			EnvoyExtensions: consulv1aplha1.EnvoyExtensions{existingExtension},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(frontendDefaults).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	config := ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		Mirror: &Mirror{
+			SourceServices: []string{"frontend", "batch"},
+			CanaryCluster:  "canary.test-service.default.dc1.internal.example.consul",
+		},
+	}
+	jsonConfig, err := json.Marshal(config)
+	require.NoError(t, err)
+	rollout := newTestRollout(jsonConfig, corev1.ConditionFalse, 0)
+
+	percentage := int32(25)
+	rpcErr := p.SetMirrorRoute(rollout, &v1alpha1.SetMirrorRoute{
+		Name:       "shadow",
+		Match:      []v1alpha1.RouteMatch{{Path: &v1alpha1.StringMatch{Prefix: "/"}}},
+		Percentage: &percentage,
+	})
+	require.Empty(t, rpcErr.ErrorString)
+
+	expectedExtension, err := mirrorExtension("test-service", "canary.test-service.default.dc1.internal.example.consul", 25)
+	require.NoError(t, err)
+
+	frontend := &consulv1aplha1.ServiceDefaults{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "frontend", Namespace: "default"}, frontend, &client.GetOptions{}))
+	require.Equal(t, consulv1aplha1.EnvoyExtensions{existingExtension, expectedExtension}, frontend.Spec.EnvoyExtensions)
+	require.Equal(t, "http", frontend.Spec.Protocol)
+
+	batch := &consulv1aplha1.ServiceDefaults{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "batch", Namespace: "default"}, batch, &client.GetOptions{}))
+	require.Equal(t, consulv1aplha1.EnvoyExtensions{expectedExtension}, batch.Spec.EnvoyExtensions)
+
+	// Removing the managed routes tears down the mirror and deletes service defaults created by the plugin
+	rpcErr = p.RemoveManagedRoutes(rollout)
+	require.Empty(t, rpcErr.ErrorString)
+
+	frontend = &consulv1aplha1.ServiceDefaults{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "frontend", Namespace: "default"}, frontend, &client.GetOptions{}))
+	require.Equal(t, consulv1aplha1.EnvoyExtensions{existingExtension}, frontend.Spec.EnvoyExtensions)
+	require.NotContains(t, frontend.Annotations, managedExtensionsAnnotation)
+	err = k8sClient.Get(context.TODO(), types.NamespacedName{Name: "batch", Namespace: "default"}, &consulv1aplha1.ServiceDefaults{}, &client.GetOptions{})
+	require.True(t, k8serrors.IsNotFound(err))
+}
+
+func TestSetMirrorRouteErrors(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		mirror        *Mirror
+		match         []v1alpha1.RouteMatch
+		expectedError string
+	}{
+		{
+			testName:      "mirror not configured",
+			match:         []v1alpha1.RouteMatch{{}},
+			expectedError: "setMirrorRoute requires mirror to be set",
+		},
+		{
+			testName:      "unsupported match",
+			mirror:        &Mirror{SourceServices: []string{"frontend"}, CanaryCluster: "canary"},
+			match:         []v1alpha1.RouteMatch{{Method: &v1alpha1.StringMatch{Exact: "GET"}}},
+			expectedError: "only an empty match or a path prefix of / is supported",
+		},
+		{
+			testName:      "invalid mirror configuration",
+			mirror:        &Mirror{SourceServices: []string{"frontend"}},
+			match:         []v1alpha1.RouteMatch{{}},
+			expectedError: "mirror.sourceServices and mirror.canaryCluster must be set",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			p := &RpcPlugin{
+				K8SClient: fake.NewClientBuilder().WithScheme(s).Build(),
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+			config := ConsulTrafficRouting{
+				ServiceName:      "test-service",
+				CanarySubsetName: "canary",
+				StableSubsetName: "stable",
+				Mirror:           testCase.mirror,
+			}
+			jsonConfig, err := json.Marshal(config)
+			require.NoError(t, err)
+			rpcErr := p.SetMirrorRoute(newTestRollout(jsonConfig, corev1.ConditionFalse, 0), &v1alpha1.SetMirrorRoute{Name: "shadow", Match: testCase.match})
+			require.Contains(t, rpcErr.ErrorString, testCase.expectedError)
+		})
+	}
+}
@@ -5,7 +5,6 @@ package plugin
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -16,25 +15,48 @@ import (
 	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
 	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
 	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-consul/pkg/utils"
 )
 
-const (
-	serviceMetaVersionAnnotation     = "consul.hashicorp.com/service-meta-%s"
-	filterServiceMetaVersionTemplate = "Service.Meta.%s == %s"
-)
-
 // ConsulTrafficRouting represents the parameters required to configure the Consul Traffic Routing plugin
 type ConsulTrafficRouting struct {
 	ServiceName                 string `json:"serviceName" protobuf:"bytes,1,opt,name=serviceName"`
 	CanarySubsetName            string `json:"canarySubsetName" protobuf:"bytes,2,opt,name=canarySubsetName"`
 	StableSubsetName            string `json:"stableSubsetName" protobuf:"bytes,3,opt,name=stableSubsetName"`
 	ServiceMetaAnnotationSuffix string `json:"serviceMetaAnnotationSuffix" protobuf:"bytes,4,opt,name=serviceMetaAnnotationSuffix"`
+	// StickySession optionally keeps clients assigned to the canary on the canary for the duration of a step
+	StickySession *StickySession `json:"stickySession,omitempty" protobuf:"bytes,5,opt,name=stickySession"`
+	// CanarySplitHeaders and StableSplitHeaders optionally set the header modifiers of the canary and stable splits
+	CanarySplitHeaders *SplitHeaders `json:"canarySplitHeaders,omitempty" protobuf:"bytes,6,opt,name=canarySplitHeaders"`
+	StableSplitHeaders *SplitHeaders `json:"stableSplitHeaders,omitempty" protobuf:"bytes,7,opt,name=stableSplitHeaders"`
+	// CanaryRoutes optionally restricts the canary to matching requests, all other requests stay on the stable subset
+	CanaryRoutes []CanaryRouteMatch `json:"canaryRoutes,omitempty" protobuf:"bytes,8,rep,name=canaryRoutes"`
+	// CanaryRoutesServiceName names the virtual service whose splitter divides the requests matched by CanaryRoutes.
+	// Defaults to <serviceName>-canary-routes
+	CanaryRoutesServiceName string `json:"canaryRoutesServiceName,omitempty" protobuf:"bytes,9,opt,name=canaryRoutesServiceName"`
+	// CanaryRouteOptions optionally sets timeouts and retries on the requests sent to the canary subset while it is in
+	// progress
+	CanaryRouteOptions *CanaryRouteOptions `json:"canaryRouteOptions,omitempty" protobuf:"bytes,10,opt,name=canaryRouteOptions"`
+	// Mirror configures where SetMirrorRoute steps mirror traffic from
+	Mirror *Mirror `json:"mirror,omitempty" protobuf:"bytes,11,opt,name=mirror"`
+	// SubsetFilterTemplate optionally replaces the Service.Meta equality filter with a Go template rendered for the
+	// canary and stable subsets
+	SubsetFilterTemplate string `json:"subsetFilterTemplate,omitempty" protobuf:"bytes,12,opt,name=subsetFilterTemplate"`
+	// Limits optionally tightens the safety limits of the plugin settings for the rollout
+	Limits *SafetyLimits `json:"limits,omitempty" protobuf:"bytes,13,opt,name=limits"`
+	// DryRun logs the changes the plugin would make instead of writing them
+	DryRun bool `json:"dryRun,omitempty" protobuf:"varint,14,opt,name=dryRun"`
+	// APIVersion is the version of the configuration, older versions are migrated to ConfigAPIVersion when parsed.
+	// Defaults to the first version
+	APIVersion string `json:"apiVersion,omitempty" protobuf:"bytes,15,opt,name=apiVersion"`
 }
 
 // RpcPlugin is the implementation of the TrafficRouterPlugin interface
@@ -42,50 +64,166 @@ type RpcPlugin struct {
 	K8SClient client.Client
 	LogCtx    *logrus.Entry
 	IsTest    bool
+	// Recorder records events on the Rollout for every change to the Consul config entries
+	Recorder record.EventRecorder
+	// Metrics records Prometheus metrics of the plugin operations
+	Metrics *Metrics
+	// SettingsFile and SettingsConfigMap, given as <namespace>/<name>, are where InitPlugin loads the plugin settings from
+	SettingsFile      string
+	SettingsConfigMap string
+	// Settings are the plugin-wide settings loaded at InitPlugin
+	Settings *PluginSettings
+	// APIReader reads from the API server when K8SClient serves reads from a cache, it is nil otherwise
+	APIReader client.Reader
+	// KubeConfig selects the cluster of the rollouts controller
+	KubeConfig utils.KubeConfigOptions
+	// ConsulKubeConfig optionally selects another cluster holding the Consul CRDs
+	ConsulKubeConfig *utils.KubeConfigOptions
+	// RolloutReader reads Rollouts, and the Deployments they reference, from the cluster of the rollouts controller.
+	// K8SClient is used if nil
+	RolloutReader client.Reader
+	// LocalClient is the client of the cluster of the rollouts controller, used by the preflight checks. K8SClient is
+	// used if nil
+	LocalClient client.Client
+	// idleCanaryOnAbort makes the abort handling of SetWeight leave the canary subset idle, whatever filter the
+	// snapshot restored. It is set by Reset.
+	idleCanaryOnAbort bool
 }
 
 var _ rolloutsPlugin.TrafficRouterPlugin = (*RpcPlugin)(nil)
 
-// InitPlugin initializes the plugin adding the consul scheme to the k8s client
-func (r *RpcPlugin) InitPlugin() pluginTypes.RpcError {
+// InitPlugin initializes the plugin adding the consul and rollouts schemes to the k8s client, and starts the cache of
+// the consul config entries
+func (r *RpcPlugin) InitPlugin() (rpcErr pluginTypes.RpcError) {
+	defer r.Metrics.observeRPC("InitPlugin", time.Now(), &rpcErr)
 	if r.IsTest {
 		return pluginTypes.RpcError{}
 	}
+	if err := r.init(true); err != nil {
+		return pluginTypes.RpcError{ErrorString: err.Error()}
+	}
+	return pluginTypes.RpcError{}
+}
 
-	cfg, err := utils.NewKubeConfig()
+// InitCLI initializes the clients of the plugin for a command line invocation. Reads go to the API server and no
+// events are recorded.
+func (r *RpcPlugin) InitCLI() error {
+	return r.init(false)
+}
+
+// init creates the clients of the plugin and loads its settings. The controller plugin records events and serves reads
+// from a cache.
+func (r *RpcPlugin) init(controller bool) error {
+	cfg, err := utils.NewKubeConfig(r.KubeConfig)
 	if err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+		return err
+	}
+	consulCfg := cfg
+	if r.ConsulKubeConfig != nil {
+		consulCfg, err = utils.NewKubeConfig(*r.ConsulKubeConfig)
+		if err != nil {
+			return err
+		}
 	}
 	s := runtime.NewScheme()
 	if err := consulv1aplha1.AddToScheme(s); err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+		return err
+	}
+	// Rollouts are read to find out whether the owner of a lock still exists
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		return err
+	}
+	// The pod template of a rollout using spec.workloadRef is read from its Deployment
+	if err := appsv1.AddToScheme(s); err != nil {
+		return err
 	}
-	r.K8SClient, err = client.New(cfg, client.Options{Scheme: s})
+	// The plugin settings can be loaded from a ConfigMap
+	if err := corev1.AddToScheme(s); err != nil {
+		return err
+	}
+	// The preflight checks review the permissions of the plugin
+	if err := authorizationv1.AddToScheme(s); err != nil {
+		return err
+	}
+	// Rollouts, their Deployments, their events and the settings ConfigMap are in the cluster of the rollouts controller
+	localClient, err := client.New(cfg, client.Options{Scheme: s})
 	if err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+		return err
+	}
+	r.RolloutReader = localClient
+	r.LocalClient = localClient
+	r.K8SClient = localClient
+	if controller {
+		r.Recorder, err = newEventRecorder(cfg, s)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRPCTimeout)
+	defer cancel()
+	r.Settings, err = r.loadSettings(ctx)
+	if err != nil {
+		return err
 	}
 
-	return pluginTypes.RpcError{}
+	// The Consul config entries are in the cluster holding the Consul CRDs
+	if consulCfg != cfg {
+		r.K8SClient, err = client.New(consulCfg, client.Options{Scheme: s})
+		if err != nil {
+			return err
+		}
+	}
+	// Missing permissions and CRDs are reported before the cache, which needs them, is started
+	if controller {
+		r.logPreflight(ctx)
+	}
+	if controller && !r.Settings.DisableCache {
+		cachedClient, err := newCachedClient(context.Background(), consulCfg, s, r.Settings.AllowedNamespaces)
+		if err != nil {
+			return err
+		}
+		r.APIReader = r.K8SClient
+		r.K8SClient = cachedClient
+	}
+
+	return nil
+}
+
+// forCall returns a copy of the plugin for an RPC call. Its log entries carry the rollout and RPC method of the call,
+// and its Kubernetes calls, including the reads of Rollouts and of the API server, that time out are logged.
+func (r *RpcPlugin) forCall(rollout *v1alpha1.Rollout, method string) *RpcPlugin {
+	call := *r
+	call.LogCtx = r.LogCtx.WithFields(logrus.Fields{
+		"namespace": rollout.GetNamespace(),
+		"rollout":   rollout.GetName(),
+		"method":    method,
+	})
+	call.K8SClient = deadlineClient{Client: r.K8SClient, plugin: &call}
+	if r.APIReader != nil {
+		call.APIReader = deadlineReader{Reader: r.APIReader, plugin: &call}
+	}
+	if r.RolloutReader != nil {
+		call.RolloutReader = deadlineReader{Reader: r.RolloutReader, plugin: &call}
+	}
+	return &call
 }
 
 // SetWeight is called each time the rollout is updated to set the weight of the subsets
-func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, desiredWeight int32, _ []v1alpha1.WeightDestination) pluginTypes.RpcError {
-	ctx := context.TODO()
-	consulConfig, err := getPluginConfig(rollout)
+func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, desiredWeight int32, _ []v1alpha1.WeightDestination) (rpcErr pluginTypes.RpcError) {
+	defer r.Metrics.observeRPC("SetWeight", time.Now(), &rpcErr)
+	r = r.forCall(rollout, "SetWeight")
+	ctx, cancel := r.rpcContext()
+	defer cancel()
+	defer retryableOnTimeout(ctx, &rpcErr)
+	consulConfig, err := getPluginConfig(rollout, r.Settings)
 	if err != nil {
 		return pluginTypes.RpcError{ErrorString: err.Error()}
 	}
+	r.LogCtx = r.LogCtx.WithField("service", consulConfig.ServiceName)
 
 	serviceName := consulConfig.ServiceName
 	canarySubsetName := consulConfig.CanarySubsetName
 	stableSubsetName := consulConfig.StableSubsetName
-	var suffix string
-	if consulConfig.ServiceMetaAnnotationSuffix != "" {
-		suffix = consulConfig.ServiceMetaAnnotationSuffix
-	} else {
-		suffix = "version"
-	}
-	serviceMetaVersion := rollout.Spec.Template.GetObjectMeta().GetAnnotations()[fmt.Sprintf(serviceMetaVersionAnnotation, suffix)]
 
 	// This checks that we are performing a canary rollout, it is not
 	// an error if this is empty. This will be empty on the initial rollout
@@ -100,29 +238,78 @@ func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, desiredWeight int32, _
 		return pluginTypes.RpcError{ErrorString: err.Error()}
 	}
 
-	if err := validateResolverSyncStatus(serviceResolver); err != nil {
+	if err := r.validateSyncStatus(ctx, serviceResolver, func() error { return validateResolverSyncStatus(serviceResolver, r.Settings.syncTimeout()) }); err != nil {
+		r.recordSyncPendingEvent(rollout, "ServiceResolver", serviceName)
+		r.Metrics.syncValidationFailed(rollout.GetNamespace(), serviceName, "ServiceResolver")
 		return pluginTypes.RpcError{ErrorString: err.Error()}
 	}
+	originalResolver := serviceResolver.DeepCopy()
+
+	// The specs are recorded before they are first modified for a revision, so that an abort can restore them
+	revision := rollout.GetAnnotations()[revisionAnnotation]
 
 	// If the rollout is successful (not aborted) then modify the resolver
+	resolverRestored := false
 	if rolloutAborted(rollout) {
-		r.LogCtx.WithFields(logrus.Fields{"canarySubsetName": canarySubsetName, "serviceResolver": serviceResolver}).Debug("Updating ServiceResolver for aborted rollout")
-		serviceResolver, err = r.updateResolverForAbortedRollout(canarySubsetName, serviceResolver)
+		restoredSpec := consulv1aplha1.ServiceResolverSpec{}
+		resolverRestored, err = restoreSnapshot(serviceResolver, revision, &restoredSpec)
 		if err != nil {
 			return pluginTypes.RpcError{ErrorString: err.Error()}
 		}
+		if resolverRestored {
+			r.LogCtx.WithFields(logrus.Fields{"revision": revision, "serviceResolver": serviceResolver}).Debug("Restoring ServiceResolver from snapshot for aborted rollout")
+			serviceResolver.Spec = restoredSpec
+			idleEmptyCanaryFilter(canarySubsetName, serviceResolver)
+			markRestored(serviceResolver, revision)
+			// The restored spec predates any load balancer the plugin created
+			if restoredSpec.LoadBalancer == nil {
+				delete(serviceResolver.Annotations, loadBalancerCreatedAnnotation)
+			}
+		} else if snapshotRestored(serviceResolver, revision) {
+			// An earlier call for the aborted revision already restored the snapshot
+			resolverRestored = true
+		} else {
+			r.LogCtx.WithFields(logrus.Fields{"canarySubsetName": canarySubsetName, "serviceResolver": serviceResolver}).Debug("Updating ServiceResolver for aborted rollout")
+			serviceResolver, err = r.updateResolverForAbortedRollout(canarySubsetName, serviceResolver)
+			if err != nil {
+				return pluginTypes.RpcError{ErrorString: err.Error()}
+			}
+		}
+		if resolverRestored && r.idleCanaryOnAbort {
+			serviceResolver, err = r.updateResolverForAbortedRollout(canarySubsetName, serviceResolver)
+			if err != nil {
+				return pluginTypes.RpcError{ErrorString: err.Error()}
+			}
+		}
 	} else {
+		// The version of a rollout referencing a Deployment is in the pod template of the Deployment
+		templateRollout, err := r.withWorkloadTemplate(ctx, rollout)
+		if err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
 		// Check if the pods have completely rolled over, and we are finished, now set the resolver to the stable version
 		if rolloutComplete(rollout) {
-			r.LogCtx.WithFields(logrus.Fields{"stableSubsetName": stableSubsetName, "canarySubsetName": canarySubsetName, "serviceMetaVersion": serviceMetaVersion, "serviceResolver": serviceResolver}).Debug("Updating ServiceResolver after completion")
-			serviceResolver, err = r.updateResolverAfterCompletion(stableSubsetName, canarySubsetName, serviceMetaVersion, suffix, serviceResolver)
+			clearSnapshot(serviceResolver)
+			filter, err := subsetFilter(consulConfig, templateRollout, stableSubsetName)
+			if err != nil {
+				return pluginTypes.RpcError{ErrorString: err.Error()}
+			}
+			r.LogCtx.WithFields(logrus.Fields{"stableSubsetName": stableSubsetName, "canarySubsetName": canarySubsetName, "filter": filter, "serviceResolver": serviceResolver}).Debug("Updating ServiceResolver after completion")
+			serviceResolver, err = r.updateResolverAfterCompletion(stableSubsetName, canarySubsetName, filter, serviceResolver)
 			if err != nil {
 				return pluginTypes.RpcError{ErrorString: err.Error()}
 			}
 		} else {
 			// Update the resolver so that canary subset points to the desired version
-			r.LogCtx.WithFields(logrus.Fields{"canarySubsetName": canarySubsetName, "serviceMetaVersion": serviceMetaVersion, "serviceResolver": serviceResolver}).Debug("Updating ServiceResolver for in progress rollout")
-			serviceResolver, err = r.updateResolverForInProgressRollouts(canarySubsetName, serviceMetaVersion, suffix, serviceResolver)
+			if err := recordSnapshot(serviceResolver, revision, serviceResolver.Spec); err != nil {
+				return pluginTypes.RpcError{ErrorString: err.Error()}
+			}
+			filter, err := subsetFilter(consulConfig, templateRollout, canarySubsetName)
+			if err != nil {
+				return pluginTypes.RpcError{ErrorString: err.Error()}
+			}
+			r.LogCtx.WithFields(logrus.Fields{"canarySubsetName": canarySubsetName, "filter": filter, "serviceResolver": serviceResolver}).Debug("Updating ServiceResolver for in progress rollout")
+			serviceResolver, err = r.updateResolverForInProgressRollouts(canarySubsetName, filter, serviceResolver)
 			if err != nil {
 				return pluginTypes.RpcError{ErrorString: err.Error()}
 			}
@@ -135,7 +322,41 @@ func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, desiredWeight int32, _
 		return pluginTypes.RpcError{ErrorString: err.Error()}
 	}
 
-	if err := validateSplitterSyncStatus(serviceSplitter); err != nil {
+	if err := r.validateSyncStatus(ctx, serviceSplitter, func() error { return validateSplitterSyncStatus(serviceSplitter, r.Settings.syncTimeout()) }); err != nil {
+		r.recordSyncPendingEvent(rollout, "ServiceSplitter", serviceName)
+		r.Metrics.syncValidationFailed(rollout.GetNamespace(), serviceName, "ServiceSplitter")
+		return pluginTypes.RpcError{ErrorString: err.Error()}
+	}
+	originalSplitter := serviceSplitter.DeepCopy()
+
+	// Only one rollout at a time may change the splitter and resolver of a service. The lock is released once the
+	// rollout completes or aborts
+	if err := r.acquireLock(ctx, rollout, serviceSplitter); err != nil {
+		return pluginTypes.RpcError{ErrorString: err.Error()}
+	}
+	if rolloutAborted(rollout) || rolloutComplete(rollout) {
+		if err := releaseLock(rollout, serviceSplitter); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+	}
+
+	splitterRestored := false
+	if rolloutAborted(rollout) {
+		restoredSpec := consulv1aplha1.ServiceSplitterSpec{}
+		splitterRestored, err = restoreSnapshot(serviceSplitter, revision, &restoredSpec)
+		if err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+		if splitterRestored {
+			r.LogCtx.WithFields(logrus.Fields{"revision": revision, "serviceSplitter": serviceSplitter}).Debug("Restoring ServiceSplitter from snapshot for aborted rollout")
+			serviceSplitter.Spec = restoredSpec
+			markRestored(serviceSplitter, revision)
+		} else if snapshotRestored(serviceSplitter, revision) {
+			splitterRestored = true
+		}
+	} else if rolloutComplete(rollout) {
+		clearSnapshot(serviceSplitter)
+	} else if err := recordSnapshot(serviceSplitter, revision, serviceSplitter.Spec); err != nil {
 		return pluginTypes.RpcError{ErrorString: err.Error()}
 	}
 
@@ -149,87 +370,295 @@ func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, desiredWeight int32, _
 		return pluginTypes.RpcError{ErrorString: fmt.Sprintf("unexpected number of service splits. Expected 2, found %d", len(serviceSplitter.Spec.Splits))}
 	}
 
-	// We only expect there to be two splits, one for the canary and one for the stable
-	// The canary subset should be the first split, represented by the desiredWeight (a percentage value), and the
-	// stable subset should be the second split, represented by 100% - desiredWeight
-	for i, split := range serviceSplitter.Spec.Splits {
-		switch split.ServiceSubset {
-		case canarySubsetName:
-			serviceSplitter.Spec.Splits[i].Weight = float32(desiredWeight)
-		case stableSubsetName:
-			serviceSplitter.Spec.Splits[i].Weight = float32(100 - desiredWeight)
-		default:
-			return pluginTypes.RpcError{ErrorString: "unexpected service split"}
+	// Sticky assignment only applies while traffic is actually being sent to an in progress canary
+	inProgress := !rolloutAborted(rollout) && !rolloutComplete(rollout)
+
+	// The limits bound the steps, the promotion to 100 after the last step is not limited
+	if inProgress && !(desiredWeight == 100 && rolloutStepsCompleted(rollout)) {
+		currentWeight, _ := splitWeight(originalSplitter, canarySubsetName)
+		// With path-scoped canaries the main splitter stays on stable, the weight is on the canary routes splitter
+		if len(consulConfig.canaryRouteMatches()) > 0 {
+			currentWeight, err = r.canaryRoutesWeight(ctx, rollout.GetNamespace(), consulConfig)
+			if err != nil {
+				return pluginTypes.RpcError{ErrorString: err.Error()}
+			}
+		}
+		if err := checkSafetyLimits(consulConfig.Limits, currentWeight, desiredWeight); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+	}
+	stickyActive := consulConfig.StickySession != nil && desiredWeight > 0 && inProgress
+	assignment := stickyAssignmentValue(rollout)
+
+	// With path-scoped canaries, or route options, the service splitter keeps all traffic on stable, and only the
+	// requests matched by the canary routes are divided by the desired weight
+	splitterWeight := desiredWeight
+	if len(consulConfig.canaryRouteMatches()) > 0 {
+		splitterWeight = 0
+	}
+
+	// A splitter restored from its snapshot is already back in its pre-rollout state
+	if !splitterRestored {
+		// We only expect there to be two splits, one for the canary and one for the stable
+		// The canary subset should be the first split, represented by the desiredWeight (a percentage value), and the
+		// stable subset should be the second split, represented by 100% - desiredWeight
+		for i, split := range serviceSplitter.Spec.Splits {
+			switch split.ServiceSubset {
+			case canarySubsetName:
+				serviceSplitter.Spec.Splits[i].Weight = float32(splitterWeight)
+				if consulConfig.CanarySplitHeaders != nil {
+					var stickyHeaders *consulv1aplha1.HTTPHeaderModifiers
+					if stickyActive {
+						stickyHeaders = stickyResponseHeaders(consulConfig.StickySession, assignment)
+					}
+					values := newSplitHeaderValues(rollout, canarySubsetName, rollout.Status.CurrentPodHash)
+					if err := setSplitHeaders(&serviceSplitter.Spec.Splits[i], consulConfig.CanarySplitHeaders, stickyHeaders, values); err != nil {
+						return pluginTypes.RpcError{ErrorString: err.Error()}
+					}
+				} else if consulConfig.StickySession != nil {
+					// The other header modifiers of the split are not owned by the plugin, only the sticky one is changed
+					setStickyResponseHeader(&serviceSplitter.Spec.Splits[i], consulConfig.StickySession, stickyActive, assignment)
+				}
+			case stableSubsetName:
+				serviceSplitter.Spec.Splits[i].Weight = float32(100 - splitterWeight)
+				if consulConfig.StableSplitHeaders != nil {
+					values := newSplitHeaderValues(rollout, stableSubsetName, rollout.Status.StableRS)
+					if err := setSplitHeaders(&serviceSplitter.Spec.Splits[i], consulConfig.StableSplitHeaders, nil, values); err != nil {
+						return pluginTypes.RpcError{ErrorString: err.Error()}
+					}
+				}
+			default:
+				return pluginTypes.RpcError{ErrorString: "unexpected service split"}
+			}
 		}
 	}
 
-	// Persist resources at end of function to prevent writing to the cluster if there is an error
+	var desiredRoutes []consulv1aplha1.ServiceRoute
+	if consulConfig.StickySession != nil && !resolverRestored {
+		serviceResolver, err = updateResolverHashPolicy(consulConfig.StickySession, stickyActive, serviceResolver)
+		if err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+		if stickyActive {
+			desiredRoutes = append(desiredRoutes, withCanaryRouteOptions(stickyRoute(consulConfig.StickySession, canarySubsetName, assignment), consulConfig.CanaryRouteOptions))
+		}
+	}
+	var canaryRoutesSplitterSplits []consulv1aplha1.ServiceSplit
+	if len(consulConfig.canaryRouteMatches()) > 0 && inProgress {
+		canaryRoutesSplitterSplits = canaryRoutesSplits(consulConfig, serviceSplitter.Spec.Splits, desiredWeight)
+		desiredRoutes = append(desiredRoutes, canaryRoutes(consulConfig.canaryRouteMatches(), consulConfig.canaryRoutesServiceName())...)
+	}
+
+	// An idle canary filter selects no instances, or every instance if it is empty, so never send traffic to it
+	if desiredWeight > 0 && canaryFilterIdle(serviceResolver.Spec.Subsets[canarySubsetName].Filter) {
+		return pluginTypes.RpcError{ErrorString: fmt.Sprintf("refusing to set the weight of canary subset %s to %d while its filter is idle", canarySubsetName, desiredWeight)}
+	}
+
+	// A dry run reports the changes instead of persisting them
+	if dryRunEnabled(consulConfig, r.Settings) {
+		if consulConfig.managesRoutes() {
+			r.LogCtx.WithField("routes", desiredRoutes).Info("Dry run, not updating managed routes")
+		}
+		if err := r.reportDryRun(rollout, []dryRunChange{
+			{kind: "ServiceSplitter", original: originalSplitter, desired: serviceSplitter},
+			{kind: "ServiceResolver", original: originalResolver, desired: serviceResolver},
+		}); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+		return pluginTypes.RpcError{}
+	}
+
+	// Persist resources at end of function to prevent writing to the cluster if there is an error. Unchanged resources
+	// are not written, and a write from a cached copy that is out of date fails on its resourceVersion.
 	// Persist changes to the ServiceSplitter
-	r.LogCtx.WithFields(logrus.Fields{"serviceSplitter": serviceSplitter}).Debug("Updating ServiceSplitter")
-	if err := r.K8SClient.Update(ctx, serviceSplitter, &client.UpdateOptions{}); err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+	if configEntryChanged(originalSplitter, serviceSplitter, originalSplitter.Spec, serviceSplitter.Spec) {
+		r.LogCtx.WithFields(logrus.Fields{"serviceSplitter": serviceSplitter}).Debug("Updating ServiceSplitter")
+		if err := r.updateConfigEntry(ctx, "ServiceSplitter", serviceSplitter); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+		r.recordSplitterEvent(rollout, originalSplitter, serviceSplitter, canarySubsetName, stableSubsetName)
+	}
+	if weight, ok := splitWeight(serviceSplitter, canarySubsetName); ok {
+		r.Metrics.setCanaryWeight(rollout.GetNamespace(), serviceName, weight)
 	}
 
 	// Persist changes to the ServiceResolver
-	r.LogCtx.WithFields(logrus.Fields{"serviceResolver": serviceResolver}).Debug("Updating ServiceResolver")
-	if err := r.K8SClient.Update(ctx, serviceResolver, &client.UpdateOptions{}); err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+	if configEntryChanged(originalResolver, serviceResolver, originalResolver.Spec, serviceResolver.Spec) {
+		r.LogCtx.WithFields(logrus.Fields{"serviceResolver": serviceResolver}).Debug("Updating ServiceResolver")
+		if err := r.updateConfigEntry(ctx, "ServiceResolver", serviceResolver); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+		r.recordResolverEvent(rollout, originalResolver, serviceResolver, canarySubsetName, stableSubsetName)
 	}
+
+	// Persist the routes managed by the plugin, only when the configuration uses the ServiceRouter
+	if consulConfig.managesRoutes() {
+		if err := r.persistManagedRoutes(ctx, rollout.GetNamespace(), consulConfig, desiredRoutes, canaryRoutesSplitterSplits); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+	}
+	r.LogCtx.WithFields(logrus.Fields{"desiredWeight": desiredWeight, "canaryFilter": serviceResolver.Spec.Subsets[canarySubsetName].Filter}).Info("Updated Consul traffic routing")
 	return pluginTypes.RpcError{}
 }
 
+// persistManagedRoutes writes the managed routes, the canary routes splitter they point to and the canary options
+// router that splitter points to. Each is written before what points to it is added, and removed after what points to
+// it is taken away, so that nothing points to a missing config entry.
+func (r *RpcPlugin) persistManagedRoutes(ctx context.Context, namespace string, consulConfig *ConsulTrafficRouting, routes []consulv1aplha1.ServiceRoute, canaryRoutesSplitterSplits []consulv1aplha1.ServiceSplit) error {
+	usesCanaryRoutes := len(consulConfig.canaryRouteMatches()) > 0
+	if usesCanaryRoutes && len(canaryRoutesSplitterSplits) > 0 {
+		if consulConfig.CanaryRouteOptions != nil {
+			route := canaryOptionsRoute(consulConfig)
+			if err := r.reconcileCanaryOptionsRouter(ctx, namespace, consulConfig.canaryOptionsServiceName(), &route); err != nil {
+				return err
+			}
+		}
+		if err := r.reconcileCanaryRoutesSplitter(ctx, namespace, consulConfig.canaryRoutesServiceName(), canaryRoutesSplitterSplits); err != nil {
+			return err
+		}
+	}
+	if err := r.reconcileManagedRoutes(ctx, namespace, consulConfig.ServiceName, routes); err != nil {
+		return err
+	}
+	if usesCanaryRoutes && len(canaryRoutesSplitterSplits) == 0 {
+		if err := r.reconcileCanaryRoutesSplitter(ctx, namespace, consulConfig.canaryRoutesServiceName(), nil); err != nil {
+			return err
+		}
+		if consulConfig.CanaryRouteOptions != nil {
+			return r.reconcileCanaryOptionsRouter(ctx, namespace, consulConfig.canaryOptionsServiceName(), nil)
+		}
+	}
+	return nil
+}
+
 // Type returns the type of the plugin
 func (r *RpcPlugin) Type() string {
+	defer r.Metrics.observeRPC("Type", time.Now(), &pluginTypes.RpcError{})
 	return Type
 }
 
 // UpdateHash is currently an empty stub to satisfy the interface
-func (r *RpcPlugin) UpdateHash(_ *v1alpha1.Rollout, _, _ string, _ []v1alpha1.WeightDestination) pluginTypes.RpcError {
+func (r *RpcPlugin) UpdateHash(_ *v1alpha1.Rollout, _, _ string, _ []v1alpha1.WeightDestination) (rpcErr pluginTypes.RpcError) {
+	defer r.Metrics.observeRPC("UpdateHash", time.Now(), &rpcErr)
 	return pluginTypes.RpcError{}
 }
 
 // SetHeaderRoute is currently an empty stub to satisfy the interface
-func (r *RpcPlugin) SetHeaderRoute(_ *v1alpha1.Rollout, _ *v1alpha1.SetHeaderRoute) pluginTypes.RpcError {
+func (r *RpcPlugin) SetHeaderRoute(_ *v1alpha1.Rollout, _ *v1alpha1.SetHeaderRoute) (rpcErr pluginTypes.RpcError) {
+	defer r.Metrics.observeRPC("SetHeaderRoute", time.Now(), &rpcErr)
 	return pluginTypes.RpcError{}
 }
 
 // VerifyWeight is currently an empty stub to satisfy the interface
-func (r *RpcPlugin) VerifyWeight(_ *v1alpha1.Rollout, _ int32, _ []v1alpha1.WeightDestination) (pluginTypes.RpcVerified, pluginTypes.RpcError) {
+func (r *RpcPlugin) VerifyWeight(_ *v1alpha1.Rollout, _ int32, _ []v1alpha1.WeightDestination) (_ pluginTypes.RpcVerified, rpcErr pluginTypes.RpcError) {
+	defer r.Metrics.observeRPC("VerifyWeight", time.Now(), &rpcErr)
 	return pluginTypes.NotImplemented, pluginTypes.RpcError{}
 }
 
-// SetMirrorRoute is currently an empty stub to satisfy the interface
-func (r *RpcPlugin) SetMirrorRoute(_ *v1alpha1.Rollout, _ *v1alpha1.SetMirrorRoute) pluginTypes.RpcError {
+// SetMirrorRoute mirrors a percentage of the requests from the configured source services to the canary subset. A
+// mirror route without a match removes the mirror.
+func (r *RpcPlugin) SetMirrorRoute(rollout *v1alpha1.Rollout, setMirrorRoute *v1alpha1.SetMirrorRoute) (rpcErr pluginTypes.RpcError) {
+	defer r.Metrics.observeRPC("SetMirrorRoute", time.Now(), &rpcErr)
+	r = r.forCall(rollout, "SetMirrorRoute")
+	ctx, cancel := r.rpcContext()
+	defer cancel()
+	defer retryableOnTimeout(ctx, &rpcErr)
+	consulConfig, err := getPluginConfig(rollout, r.Settings)
+	if err != nil {
+		return pluginTypes.RpcError{ErrorString: err.Error()}
+	}
+	r.LogCtx = r.LogCtx.WithField("service", consulConfig.ServiceName)
+	if consulConfig.Mirror == nil {
+		return pluginTypes.RpcError{ErrorString: "setMirrorRoute requires mirror to be set in the consul traffic routing configuration"}
+	}
+
+	var extension *consulv1aplha1.EnvoyExtension
+	if setMirrorRoute.Match != nil {
+		if err := validateMirrorMatch(setMirrorRoute.Match); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+		percentage := int32(100)
+		if setMirrorRoute.Percentage != nil {
+			percentage = *setMirrorRoute.Percentage
+		}
+		ext, err := mirrorExtension(consulConfig.ServiceName, consulConfig.Mirror.CanaryCluster, percentage)
+		if err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+		extension = &ext
+	}
+
+	if dryRunEnabled(consulConfig, r.Settings) {
+		r.LogCtx.WithFields(logrus.Fields{"mirrorRoute": setMirrorRoute.Name, "extension": extension}).Info("Dry run, not updating mirror route")
+		return pluginTypes.RpcError{}
+	}
+	r.LogCtx.WithFields(logrus.Fields{"mirrorRoute": setMirrorRoute.Name, "extension": extension}).Debug("Updating mirror route")
+	if err := r.reconcileMirrorExtension(ctx, rollout.GetNamespace(), consulConfig.Mirror.SourceServices, setMirrorRoute.Name, extension); err != nil {
+		return pluginTypes.RpcError{ErrorString: err.Error()}
+	}
 	return pluginTypes.RpcError{}
 }
 
-// RemoveManagedRoutes is currently an empty stub to satisfy the interface
-func (r *RpcPlugin) RemoveManagedRoutes(_ *v1alpha1.Rollout) pluginTypes.RpcError {
+// RemoveManagedRoutes removes every ServiceRouter route and mirror written by the plugin for the rollout
+func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) (rpcErr pluginTypes.RpcError) {
+	defer r.Metrics.observeRPC("RemoveManagedRoutes", time.Now(), &rpcErr)
+	r = r.forCall(rollout, "RemoveManagedRoutes")
+	ctx, cancel := r.rpcContext()
+	defer cancel()
+	defer retryableOnTimeout(ctx, &rpcErr)
+	consulConfig, err := getPluginConfig(rollout, r.Settings)
+	if err != nil {
+		return pluginTypes.RpcError{ErrorString: err.Error()}
+	}
+	r.LogCtx = r.LogCtx.WithField("service", consulConfig.ServiceName)
+	if dryRunEnabled(consulConfig, r.Settings) {
+		r.LogCtx.Info("Dry run, not removing managed routes")
+		return pluginTypes.RpcError{}
+	}
+	if consulConfig.managesRoutes() {
+		if err := r.persistManagedRoutes(ctx, rollout.GetNamespace(), consulConfig, nil, nil); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+	}
+	if consulConfig.Mirror != nil {
+		if err := r.removeMirrorExtensions(ctx, rollout.GetNamespace(), consulConfig.Mirror.SourceServices); err != nil {
+			return pluginTypes.RpcError{ErrorString: err.Error()}
+		}
+	}
 	return pluginTypes.RpcError{}
 }
 
-func (r *RpcPlugin) updateResolverAfterCompletion(stableSubsetName, canarySubsetName, serviceMetaVersion, suffix string, sr *consulv1aplha1.ServiceResolver) (*consulv1aplha1.ServiceResolver, error) {
+func (r *RpcPlugin) updateResolverAfterCompletion(stableSubsetName, canarySubsetName, stableFilter string, sr *consulv1aplha1.ServiceResolver) (*consulv1aplha1.ServiceResolver, error) {
 	var err error
-	sr, err = r.updateResolverSubsetForRollouts(canarySubsetName, "", sr)
+	sr, err = r.updateResolverSubsetForRollouts(canarySubsetName, idleCanaryFilter, sr)
 	if err != nil {
 		return nil, err
 	}
 	// Update the resolver so that stable subset points to the former canary version
-	sr, err = r.updateResolverSubsetForRollouts(stableSubsetName, fmt.Sprintf(filterServiceMetaVersionTemplate, suffix, serviceMetaVersion), sr)
+	sr, err = r.updateResolverSubsetForRollouts(stableSubsetName, stableFilter, sr)
 	if err != nil {
 		return nil, err
 	}
 	return sr, nil
 }
 
-// updateResolverForInProgressRollouts sets the canary filter to the serviceMetaVersion passed in
-func (r *RpcPlugin) updateResolverForInProgressRollouts(canarySubsetName, serviceMetaVersion, suffix string, sr *consulv1aplha1.ServiceResolver) (*consulv1aplha1.ServiceResolver, error) {
-	return r.updateResolverSubsetForRollouts(canarySubsetName, fmt.Sprintf(filterServiceMetaVersionTemplate, suffix, serviceMetaVersion), sr)
+// updateResolverForInProgressRollouts sets the canary filter to the filter passed in
+func (r *RpcPlugin) updateResolverForInProgressRollouts(canarySubsetName, canaryFilter string, sr *consulv1aplha1.ServiceResolver) (*consulv1aplha1.ServiceResolver, error) {
+	return r.updateResolverSubsetForRollouts(canarySubsetName, canaryFilter, sr)
 }
 
-// updateResolverForAbortedRollout sets the canary filter to empty if we've aborted the rollout
+// updateResolverForAbortedRollout sets the canary filter to idle if we've aborted the rollout
 func (r *RpcPlugin) updateResolverForAbortedRollout(canarySubsetName string, sr *consulv1aplha1.ServiceResolver) (*consulv1aplha1.ServiceResolver, error) {
-	return r.updateResolverSubsetForRollouts(canarySubsetName, "", sr)
+	return r.updateResolverSubsetForRollouts(canarySubsetName, idleCanaryFilter, sr)
+}
+
+// idleEmptyCanaryFilter makes the canary subset idle when it has no filter, which would match every instance
+func idleEmptyCanaryFilter(canarySubsetName string, sr *consulv1aplha1.ServiceResolver) {
+	subset, ok := sr.Spec.Subsets[canarySubsetName]
+	if ok && canaryFilterIdle(subset.Filter) {
+		subset.Filter = idleCanaryFilter
+		sr.Spec.Subsets[canarySubsetName] = subset
+	}
 }
 
 func (r *RpcPlugin) updateResolverSubsetForRollouts(subsetName, filterValue string, sr *consulv1aplha1.ServiceResolver) (*consulv1aplha1.ServiceResolver, error) {
@@ -243,6 +672,16 @@ func (r *RpcPlugin) updateResolverSubsetForRollouts(subsetName, filterValue stri
 	return sr, nil
 }
 
+// splitWeight returns the weight of the split of the subset, and whether the splitter has one
+func splitWeight(splitter *consulv1aplha1.ServiceSplitter, subsetName string) (float32, bool) {
+	for _, split := range splitter.Spec.Splits {
+		if split.ServiceSubset == subsetName {
+			return split.Weight, true
+		}
+	}
+	return 0, false
+}
+
 func rolloutComplete(rollout *v1alpha1.Rollout) bool {
 	rolloutCondition, err := completeCondition(rollout)
 	if err != nil {
@@ -265,9 +704,30 @@ func rolloutAborted(rollout *v1alpha1.Rollout) bool {
 	return rollout.Status.Abort
 }
 
-func getPluginConfig(rollout *v1alpha1.Rollout) (*ConsulTrafficRouting, error) {
+// rolloutStepsCompleted is true once the rollout is past its last step. The rollouts controller then sets the canary
+// weight to 100 to promote the canary, before the rollout is complete.
+func rolloutStepsCompleted(rollout *v1alpha1.Rollout) bool {
+	index := rollout.Status.CurrentStepIndex
+	return index != nil && rollout.Spec.Strategy.Canary != nil && int(*index) >= len(rollout.Spec.Strategy.Canary.Steps)
+}
+
+// getPluginConfig parses the configuration of the rollout and merges it with the plugin settings
+func getPluginConfig(rollout *v1alpha1.Rollout, settings *PluginSettings) (*ConsulTrafficRouting, error) {
+	if !settings.namespaceAllowed(rollout.GetNamespace()) {
+		return nil, fmt.Errorf("namespace %s is not one of the allowed namespaces of the plugin", rollout.GetNamespace())
+	}
+	data, err := pluginConfigData(rollout)
+	if err != nil {
+		return nil, err
+	}
 	consulConfig := ConsulTrafficRouting{}
-	if err := json.Unmarshal(rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[ConfigKey], &consulConfig); err != nil {
+	if err := decodeConfig(data, &consulConfig); err != nil {
+		return nil, err
+	}
+	if err := validateSafetyLimits("limits", consulConfig.Limits); err != nil {
+		return nil, errors.New("invalid consul traffic routing configuration. " + err.Error())
+	}
+	if err := applySettings(&consulConfig, settings); err != nil {
 		return nil, err
 	}
 	if err := validateConfig(consulConfig); err != nil {
@@ -280,15 +740,38 @@ func validateConfig(cfg ConsulTrafficRouting) error {
 	if cfg.StableSubsetName == "" || cfg.CanarySubsetName == "" || cfg.ServiceName == "" {
 		return errors.New("invalid consul traffic routing configuration. stableSubsetName, canarySubsetName, and serviceName must be set")
 	}
-	return nil
+	if err := validateStickySession(cfg.StickySession); err != nil {
+		return err
+	}
+	if err := validateSplitHeaders("canarySplitHeaders", cfg.CanarySplitHeaders); err != nil {
+		return err
+	}
+	if err := validateSplitHeaders("stableSplitHeaders", cfg.StableSplitHeaders); err != nil {
+		return err
+	}
+	if err := validateCanaryRoutes(cfg.CanaryRoutes); err != nil {
+		return err
+	}
+	if err := validateCanaryRouteOptions(cfg); err != nil {
+		return err
+	}
+	if err := validateMirror(cfg.Mirror); err != nil {
+		return err
+	}
+	return validateSubsetFilterTemplate(cfg.SubsetFilterTemplate)
+}
+
+// managesRoutes reports whether the configuration requires the plugin to manage ServiceRouter routes
+func (c *ConsulTrafficRouting) managesRoutes() bool {
+	return c.StickySession != nil || len(c.CanaryRoutes) > 0 || c.CanaryRouteOptions != nil
 }
 
 // validateResolverSyncStatus checks if the resolver has synced with Consul, this is necessary to ensure that the resolver
 // is up-to-date before the rollout can continue
-func validateResolverSyncStatus(resolver *consulv1aplha1.ServiceResolver) error {
+func validateResolverSyncStatus(resolver *consulv1aplha1.ServiceResolver, syncTimeout time.Duration) error {
 	for _, condition := range resolver.Status.Conditions {
 		if condition.Type == consulv1aplha1.ConditionSynced {
-			if condition.Status != corev1.ConditionTrue || overTwoSeconds(condition.LastTransitionTime.Time, resolver.LastSyncedTime.Time) {
+			if condition.Status != corev1.ConditionTrue || exceedsSyncTimeout(condition.LastTransitionTime.Time, resolver.LastSyncedTime.Time, syncTimeout) {
 				return errors.New("service resolver has not synced with Consul. The service resolver needs to be up to date before rollout can continue")
 			}
 		}
@@ -298,10 +781,10 @@ func validateResolverSyncStatus(resolver *consulv1aplha1.ServiceResolver) error
 
 // validateSplitterSyncStatus checks if the splitter has synced with Consul, this is necessary to ensure that the splitter
 // is up-to-date before the rollout can continue
-func validateSplitterSyncStatus(splitter *consulv1aplha1.ServiceSplitter) error {
+func validateSplitterSyncStatus(splitter *consulv1aplha1.ServiceSplitter, syncTimeout time.Duration) error {
 	for _, condition := range splitter.Status.Conditions {
 		if condition.Type == consulv1aplha1.ConditionSynced {
-			if condition.Status != corev1.ConditionTrue || overTwoSeconds(condition.LastTransitionTime.Time, splitter.LastSyncedTime.Time) {
+			if condition.Status != corev1.ConditionTrue || exceedsSyncTimeout(condition.LastTransitionTime.Time, splitter.LastSyncedTime.Time, syncTimeout) {
 				return errors.New("service splitter has not synced with Consul. The service splitter needs to be up to date before rollout can continue")
 			}
 		}
@@ -309,6 +792,23 @@ func validateSplitterSyncStatus(splitter *consulv1aplha1.ServiceSplitter) error
 	return nil
 }
 
-func overTwoSeconds(t1, t2 time.Time) bool {
-	return t1.Sub(t2).Abs() > 2*time.Second
+// validateSyncStatus runs validate on obj. When it fails on an object read from a cache, which may lag behind the sync
+// status written by the Consul controller, obj is read from the API server and validated again.
+func (r *RpcPlugin) validateSyncStatus(ctx context.Context, obj client.Object, validate func() error) error {
+	err := validate()
+	if err == nil {
+		return nil
+	}
+	live, readErr := r.readLive(ctx, obj)
+	if readErr != nil {
+		return readErr
+	}
+	if !live {
+		return err
+	}
+	return validate()
+}
+
+func exceedsSyncTimeout(t1, t2 time.Time, syncTimeout time.Duration) bool {
+	return t1.Sub(t2).Abs() > syncTimeout
 }
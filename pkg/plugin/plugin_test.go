@@ -12,8 +12,10 @@ import (
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -92,7 +94,7 @@ func TestSetWeight(t *testing.T) {
 							Filter: "Service.Meta.version == 1",
 						},
 						"canary": {
-							Filter: "Service.Meta.version == 2",
+							Filter: `Service.Meta.version == "2"`,
 						},
 					},
 				},
@@ -176,7 +178,7 @@ func TestSetWeight(t *testing.T) {
 							Filter: "Service.Meta.version == 1",
 						},
 						"canary": {
-							Filter: "Service.Meta.version == 2",
+							Filter: `Service.Meta.version == "2"`,
 						},
 					},
 				},
@@ -260,7 +262,7 @@ func TestSetWeight(t *testing.T) {
 							Filter: "Service.Meta.version == 1",
 						},
 						"canary": {
-							Filter: "Service.Meta.version == 2",
+							Filter: `Service.Meta.version == "2"`,
 						},
 					},
 				},
@@ -344,7 +346,7 @@ func TestSetWeight(t *testing.T) {
 							Filter: "Service.Meta.version == 1",
 						},
 						"canary": {
-							Filter: "Service.Meta.version == 2",
+							Filter: `Service.Meta.version == "2"`,
 						},
 					},
 				},
@@ -425,10 +427,10 @@ func TestSetWeight(t *testing.T) {
 				Spec: consulv1aplha1.ServiceResolverSpec{
 					Subsets: map[string]consulv1aplha1.ServiceResolverSubset{
 						"stable": {
-							Filter: "Service.Meta.version == 2",
+							Filter: `Service.Meta.version == "2"`,
 						},
 						"canary": {
-							Filter: "",
+							Filter: idleCanaryFilter,
 						},
 					},
 				},
@@ -512,7 +514,7 @@ func TestSetWeight(t *testing.T) {
 							Filter: "Service.Meta.version == 1",
 						},
 						"canary": {
-							Filter: "Service.Meta.version == 2",
+							Filter: `Service.Meta.version == "2"`,
 						},
 					},
 				},
@@ -598,7 +600,7 @@ func TestSetWeight(t *testing.T) {
 							Filter: "Service.Meta.version == 1",
 						},
 						"canary": {
-							Filter: "",
+							Filter: idleCanaryFilter,
 						},
 					},
 				},
@@ -697,7 +699,7 @@ func TestSetWeight(t *testing.T) {
 							Filter: "Service.Meta.number == 1",
 						},
 						"canary": {
-							Filter: "Service.Meta.number == 2",
+							Filter: `Service.Meta.number == "2"`,
 						},
 					},
 				},
@@ -1831,3 +1833,565 @@ func unknownSyncTime(t *testing.T) time.Time {
 	require.NoError(t, err)
 	return parsedTime
 }
+
+func TestSetWeightStickySession(t *testing.T) {
+	stepIndex := int32(2)
+	testCases := []struct {
+		testName               string
+		sticky                 StickySession
+		routeOptions           *CanaryRouteOptions
+		complete               bool
+		desiredWeight          int32
+		inputRouter            *consulv1aplha1.ServiceRouter
+		expectedRoutes         []consulv1aplha1.ServiceRoute
+		expectedHeaders        *consulv1aplha1.HTTPHeaderModifiers
+		expectedLoadBalancer   *consulv1aplha1.LoadBalancer
+		expectedRouterNotFound bool
+	}{
+		{
+			testName:      "in progress with cookie creates router",
+			sticky:        StickySession{CookieName: "canary"},
+			desiredWeight: 20,
+			expectedRoutes: []consulv1aplha1.ServiceRoute{
+				{
+					Match: &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+						Header: []consulv1aplha1.ServiceRouteHTTPMatchHeader{{Name: "cookie", Regex: `(.*;\s*)?canary=abc123-2(;.*)?`}},
+					}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{ServiceSubset: "canary"},
+				},
+			},
+			expectedHeaders: &consulv1aplha1.HTTPHeaderModifiers{Add: map[string]string{"Set-Cookie": "canary=abc123-2; Path=/"}},
+			expectedLoadBalancer: &consulv1aplha1.LoadBalancer{
+				Policy:       "ring_hash",
+				HashPolicies: []consulv1aplha1.HashPolicy{{Field: "cookie", FieldValue: "canary"}},
+			},
+		},
+		{
+			testName:      "in progress with header keeps existing routes",
+			sticky:        StickySession{HeaderName: "x-canary"},
+			desiredWeight: 20,
+			inputRouter: &consulv1aplha1.ServiceRouter{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-service", Namespace: "default"},
+				Spec: consulv1aplha1.ServiceRouterSpec{Routes: []consulv1aplha1.ServiceRoute{
+					{
+						Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/admin"}},
+						Destination: &consulv1aplha1.ServiceRouteDestination{Service: "admin"},
+					},
+				}},
+			},
+			expectedRoutes: []consulv1aplha1.ServiceRoute{
+				{
+					Match: &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+						Header: []consulv1aplha1.ServiceRouteHTTPMatchHeader{{Name: "x-canary", Exact: "abc123-2"}},
+					}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{ServiceSubset: "canary"},
+				},
+				{
+					Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/admin"}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{Service: "admin"},
+				},
+			},
+			expectedHeaders: &consulv1aplha1.HTTPHeaderModifiers{Set: map[string]string{"x-canary": "abc123-2"}},
+			expectedLoadBalancer: &consulv1aplha1.LoadBalancer{
+				Policy:       "ring_hash",
+				HashPolicies: []consulv1aplha1.HashPolicy{{Field: "header", FieldValue: "x-canary"}},
+			},
+		},
+		{
+			testName: "in progress with canary route options",
+			sticky:   StickySession{HeaderName: "x-canary"},
+			routeOptions: &CanaryRouteOptions{
+				RequestTimeout:        metav1.Duration{Duration: 2 * time.Second},
+				NumRetries:            3,
+				RetryOnConnectFailure: true,
+				RetryOnStatusCodes:    []uint32{503},
+			},
+			desiredWeight: 20,
+			expectedRoutes: []consulv1aplha1.ServiceRoute{
+				{
+					Match: &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+						Header: []consulv1aplha1.ServiceRouteHTTPMatchHeader{{Name: "x-canary", Exact: "abc123-2"}},
+					}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{
+						ServiceSubset:         "canary",
+						RequestTimeout:        metav1.Duration{Duration: 2 * time.Second},
+						NumRetries:            3,
+						RetryOnConnectFailure: true,
+						RetryOnStatusCodes:    []uint32{503},
+					},
+				},
+				{
+					Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/"}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{Service: "test-service-canary-routes"},
+				},
+			},
+			expectedHeaders: &consulv1aplha1.HTTPHeaderModifiers{Set: map[string]string{"x-canary": "abc123-2"}},
+			expectedLoadBalancer: &consulv1aplha1.LoadBalancer{
+				Policy:       "ring_hash",
+				HashPolicies: []consulv1aplha1.HashPolicy{{Field: "header", FieldValue: "x-canary"}},
+			},
+		},
+		{
+			testName:      "completed removes plugin created router",
+			sticky:        StickySession{CookieName: "canary"},
+			complete:      true,
+			desiredWeight: 0,
+			inputRouter: &consulv1aplha1.ServiceRouter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-service",
+					Namespace: "default",
+					Annotations: map[string]string{
+						createdByPluginAnnotation: "true",
+						managedRoutesAnnotation:   `[{"match":{"http":{"header":[{"name":"cookie","regex":"(.*;\\s*)?canary=abc123-1(;.*)?"}]}},"destination":{"serviceSubset":"canary"}}]`,
+					},
+				},
+				Spec: consulv1aplha1.ServiceRouterSpec{Routes: []consulv1aplha1.ServiceRoute{
+					{
+						Match: &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+							Header: []consulv1aplha1.ServiceRouteHTTPMatchHeader{{Name: "cookie", Regex: `(.*;\s*)?canary=abc123-1(;.*)?`}},
+						}},
+						Destination: &consulv1aplha1.ServiceRouteDestination{ServiceSubset: "canary"},
+					},
+				}},
+			},
+			expectedRouterNotFound: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+
+			objs := []client.Object{defaultResolver(), defaultSplitter()}
+			if testCase.inputRouter != nil {
+				objs = append(objs, testCase.inputRouter)
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+			p := &RpcPlugin{
+				K8SClient: k8sClient,
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+
+			config := ConsulTrafficRouting{
+				ServiceName:        "test-service",
+				CanarySubsetName:   "canary",
+				StableSubsetName:   "stable",
+				StickySession:      &testCase.sticky,
+				CanaryRouteOptions: testCase.routeOptions,
+			}
+			jsonConfig, err := json.Marshal(config)
+			require.NoError(t, err)
+			completed := corev1.ConditionFalse
+			if testCase.complete {
+				completed = corev1.ConditionTrue
+			}
+			rollout := newTestRollout(jsonConfig, completed, testCase.desiredWeight)
+			rollout.Status.CurrentPodHash = "abc123"
+			rollout.Status.CurrentStepIndex = &stepIndex
+
+			rpcErr := p.SetWeight(rollout, testCase.desiredWeight, []v1alpha1.WeightDestination{})
+			require.Empty(t, rpcErr.ErrorString)
+
+			namespacedName := types.NamespacedName{Name: "test-service", Namespace: "default"}
+			actualResolver := &consulv1aplha1.ServiceResolver{}
+			actualSplitter := &consulv1aplha1.ServiceSplitter{}
+			actualRouter := &consulv1aplha1.ServiceRouter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualResolver, &client.GetOptions{}))
+			require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+			require.Equal(t, testCase.expectedLoadBalancer, actualResolver.Spec.LoadBalancer)
+			for _, split := range actualSplitter.Spec.Splits {
+				if split.ServiceSubset == "canary" {
+					require.Equal(t, testCase.expectedHeaders, split.ResponseHeaders)
+				} else {
+					require.Nil(t, split.ResponseHeaders)
+				}
+			}
+
+			err = k8sClient.Get(context.TODO(), namespacedName, actualRouter, &client.GetOptions{})
+			if testCase.expectedRouterNotFound {
+				require.True(t, k8serrors.IsNotFound(err))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedRoutes, actualRouter.Spec.Routes)
+		})
+	}
+}
+
+func TestSetWeightStickySessionKeepsSplitHeaders(t *testing.T) {
+	stepIndex := int32(1)
+	requestHeaders := &consulv1aplha1.HTTPHeaderModifiers{Set: map[string]string{"x-team": "payments"}}
+	responseHeaders := &consulv1aplha1.HTTPHeaderModifiers{Set: map[string]string{"x-served-by": "canary"}, Remove: []string{"server"}}
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	inputSplitter := defaultSplitter()
+	inputSplitter.Spec.Splits[1].RequestHeaders = requestHeaders.DeepCopy()
+	inputSplitter.Spec.Splits[1].ResponseHeaders = responseHeaders.DeepCopy()
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), inputSplitter).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	jsonConfig, err := json.Marshal(ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		StickySession:    &StickySession{CookieName: "canary"},
+	})
+	require.NoError(t, err)
+	namespacedName := types.NamespacedName{Name: "test-service", Namespace: "default"}
+
+	// In progress, the sticky cookie is added next to the existing header modifiers
+	rollout := newTestRollout(jsonConfig, corev1.ConditionFalse, 20)
+	rollout.Status.CurrentPodHash = "abc123"
+	rollout.Status.CurrentStepIndex = &stepIndex
+	rpcErr := p.SetWeight(rollout, 20, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	actualSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+	require.Equal(t, requestHeaders, actualSplitter.Spec.Splits[1].RequestHeaders)
+	require.Equal(t, &consulv1aplha1.HTTPHeaderModifiers{
+		Add:    map[string]string{"Set-Cookie": "canary=abc123-1; Path=/"},
+		Set:    map[string]string{"x-served-by": "canary"},
+		Remove: []string{"server"},
+	}, actualSplitter.Spec.Splits[1].ResponseHeaders)
+
+	// Once complete, only the sticky cookie is removed
+	rpcErr = p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionTrue, 0), 0, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+	require.Equal(t, requestHeaders, actualSplitter.Spec.Splits[1].RequestHeaders)
+	require.Equal(t, responseHeaders, actualSplitter.Spec.Splits[1].ResponseHeaders)
+}
+
+func TestUpdateResolverHashPolicy(t *testing.T) {
+	sticky := &StickySession{CookieName: "canary"}
+	ringHash := consulv1aplha1.LoadBalancer{Policy: "ring_hash"}
+
+	// A load balancer created by the plugin is removed with the hash policy
+	resolver, err := updateResolverHashPolicy(sticky, true, defaultResolver())
+	require.NoError(t, err)
+	require.Equal(t, []consulv1aplha1.HashPolicy{stickyHashPolicy(sticky)}, resolver.Spec.LoadBalancer.HashPolicies)
+	require.Equal(t, "true", resolver.Annotations[loadBalancerCreatedAnnotation])
+	resolver, err = updateResolverHashPolicy(sticky, false, resolver)
+	require.NoError(t, err)
+	require.Nil(t, resolver.Spec.LoadBalancer)
+	require.NotContains(t, resolver.Annotations, loadBalancerCreatedAnnotation)
+
+	// A ring_hash load balancer written by hand is kept
+	handWritten := defaultResolver()
+	handWritten.Spec.LoadBalancer = ringHash.DeepCopy()
+	resolver, err = updateResolverHashPolicy(sticky, true, handWritten)
+	require.NoError(t, err)
+	require.NotContains(t, resolver.Annotations, loadBalancerCreatedAnnotation)
+	resolver, err = updateResolverHashPolicy(sticky, false, resolver)
+	require.NoError(t, err)
+	require.Equal(t, &ringHash, resolver.Spec.LoadBalancer)
+}
+
+func TestSetWeightStickySessionInvalidConfig(t *testing.T) {
+	require.Error(t, validateConfig(ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		StickySession:    &StickySession{CookieName: "canary", HeaderName: "x-canary"},
+	}))
+	require.Error(t, validateConfig(ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		StickySession:    &StickySession{},
+	}))
+}
+
+func TestSetWeightSplitHeaders(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+
+	config := ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		CanarySplitHeaders: &SplitHeaders{
+			RequestHeaders: &consulv1aplha1.HTTPHeaderModifiers{
+				Set: map[string]string{"x-rollout-version": "canary", "x-rollout-revision": "{{ .Revision }}"},
+			},
+		},
+		StableSplitHeaders: &SplitHeaders{
+			ResponseHeaders: &consulv1aplha1.HTTPHeaderModifiers{
+				Add: map[string]string{"x-rollout-version": "{{ .Subset }}-{{ .PodHash }}"},
+			},
+		},
+	}
+	jsonConfig, err := json.Marshal(config)
+	require.NoError(t, err)
+	rollout := newTestRollout(jsonConfig, corev1.ConditionFalse, 30)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "4"}
+	rollout.Status.StableRS = "def456"
+
+	rpcErr := p.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	actualSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualSplitter, &client.GetOptions{}))
+	require.ElementsMatch(t, []consulv1aplha1.ServiceSplit{
+		{
+			Weight:        30,
+			ServiceSubset: "canary",
+			RequestHeaders: &consulv1aplha1.HTTPHeaderModifiers{
+				Set: map[string]string{"x-rollout-version": "canary", "x-rollout-revision": "4"},
+			},
+		},
+		{
+			Weight:        70,
+			ServiceSubset: "stable",
+			ResponseHeaders: &consulv1aplha1.HTTPHeaderModifiers{
+				Add: map[string]string{"x-rollout-version": "stable-def456"},
+			},
+		},
+	}, actualSplitter.Spec.Splits)
+}
+
+func TestSetWeightMissingVersionAnnotation(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 20)
+	rollout.Spec.Template.Annotations = map[string]string{"consul.hashicorp.com/service-meta-version": ""}
+
+	rpcErr := p.SetWeight(rollout, 20, []v1alpha1.WeightDestination{})
+	require.Contains(t, rpcErr.ErrorString, "annotation consul.hashicorp.com/service-meta-version is missing or empty on the pod template of rollout default/rollout")
+
+	actualResolver := &consulv1aplha1.ServiceResolver{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualResolver, &client.GetOptions{}))
+	require.Equal(t, defaultResolver().Spec.Subsets, actualResolver.Spec.Subsets)
+}
+
+func TestSetWeightRefusesWeightForIdleCanary(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 20)
+	rollout.Status.Abort = true
+	rollout.Status.AbortedAt = &metav1.Time{Time: time.Now()}
+
+	rpcErr := p.SetWeight(rollout, 20, []v1alpha1.WeightDestination{})
+	require.Equal(t, "refusing to set the weight of canary subset canary to 20 while its filter is idle", rpcErr.ErrorString)
+
+	actualSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualSplitter, &client.GetOptions{}))
+	require.ElementsMatch(t, defaultSplitter().Spec.Splits, actualSplitter.Spec.Splits)
+}
+
+func TestValidateConfigCanaryRouteOptions(t *testing.T) {
+	err := validateConfig(ConsulTrafficRouting{
+		ServiceName:        "test-service",
+		CanarySubsetName:   "canary",
+		StableSubsetName:   "stable",
+		CanaryRouteOptions: &CanaryRouteOptions{NumRetries: 2},
+	})
+	require.NoError(t, err)
+
+	err = validateConfig(ConsulTrafficRouting{
+		ServiceName:        "test-service",
+		CanarySubsetName:   "canary",
+		StableSubsetName:   "stable",
+		CanaryRouteOptions: &CanaryRouteOptions{RetryOnStatusCodes: []uint32{42}},
+	})
+	require.ErrorContains(t, err, "invalid status code 42")
+}
+
+func TestSetWeightCanaryRouteOptions(t *testing.T) {
+	options := &CanaryRouteOptions{RequestTimeout: metav1.Duration{Duration: 2 * time.Second}, NumRetries: 3}
+	adminRoute := consulv1aplha1.ServiceRoute{
+		Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/admin"}},
+		Destination: &consulv1aplha1.ServiceRouteDestination{Service: "admin"},
+	}
+	testCases := map[string]struct {
+		canaryRoutes   []CanaryRouteMatch
+		expectedRoutes []consulv1aplha1.ServiceRoute
+	}{
+		"all requests after existing routes": {
+			expectedRoutes: []consulv1aplha1.ServiceRoute{
+				adminRoute,
+				{
+					Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/"}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{Service: "test-service-canary-routes"},
+				},
+			},
+		},
+		"canary routes": {
+			canaryRoutes: []CanaryRouteMatch{{PathPrefix: "/v2/"}},
+			expectedRoutes: []consulv1aplha1.ServiceRoute{
+				{
+					Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/v2/"}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{Service: "test-service-canary-routes"},
+				},
+				adminRoute,
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			inputRouter := &consulv1aplha1.ServiceRouter{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-service", Namespace: "default"},
+				Spec:       consulv1aplha1.ServiceRouterSpec{Routes: []consulv1aplha1.ServiceRoute{adminRoute}},
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter(), inputRouter).Build()
+			p := &RpcPlugin{
+				K8SClient: k8sClient,
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+			config := ConsulTrafficRouting{
+				ServiceName:        "test-service",
+				CanarySubsetName:   "canary",
+				StableSubsetName:   "stable",
+				CanaryRoutes:       testCase.canaryRoutes,
+				CanaryRouteOptions: options,
+			}
+			jsonConfig, err := json.Marshal(config)
+			require.NoError(t, err)
+			namespacedName := types.NamespacedName{Name: "test-service", Namespace: "default"}
+			routesName := types.NamespacedName{Name: "test-service-canary-routes", Namespace: "default"}
+			optionsName := types.NamespacedName{Name: "test-service-canary-options", Namespace: "default"}
+
+			rpcErr := p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionFalse, 20), 20, []v1alpha1.WeightDestination{})
+			require.Empty(t, rpcErr.ErrorString)
+
+			// The routes of the service carry no options, so stable requests are not affected
+			actualRouter := &consulv1aplha1.ServiceRouter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualRouter, &client.GetOptions{}))
+			require.Equal(t, testCase.expectedRoutes, actualRouter.Spec.Routes)
+			actualSplitter := &consulv1aplha1.ServiceSplitter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+			require.ElementsMatch(t, []consulv1aplha1.ServiceSplit{
+				{Weight: 100, ServiceSubset: "stable"},
+				{Weight: 0, ServiceSubset: "canary"},
+			}, actualSplitter.Spec.Splits)
+
+			// The canary share of the split goes through the canary options virtual service
+			routesSplitter := &consulv1aplha1.ServiceSplitter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), routesName, routesSplitter, &client.GetOptions{}))
+			require.ElementsMatch(t, []consulv1aplha1.ServiceSplit{
+				{Weight: 80, Service: "test-service", ServiceSubset: "stable"},
+				{Weight: 20, Service: "test-service-canary-options"},
+			}, routesSplitter.Spec.Splits)
+			optionsRouter := &consulv1aplha1.ServiceRouter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), optionsName, optionsRouter, &client.GetOptions{}))
+			require.Equal(t, []consulv1aplha1.ServiceRoute{
+				{
+					Match: &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/"}},
+					Destination: &consulv1aplha1.ServiceRouteDestination{
+						Service:        "test-service",
+						ServiceSubset:  "canary",
+						RequestTimeout: options.RequestTimeout,
+						NumRetries:     options.NumRetries,
+					},
+				},
+			}, optionsRouter.Spec.Routes)
+
+			// The next step reads its current weight from the canary routes splitter
+			rpcErr = p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionFalse, 40), 40, []v1alpha1.WeightDestination{})
+			require.Empty(t, rpcErr.ErrorString)
+			weight, err := p.canaryRoutesWeight(context.TODO(), "default", &config)
+			require.NoError(t, err)
+			require.Equal(t, float32(40), weight)
+
+			// The routes, the canary routes splitter and the canary options router are removed once the rollout completes
+			rpcErr = p.SetWeight(newTestRollout(jsonConfig, corev1.ConditionTrue, 0), 0, []v1alpha1.WeightDestination{})
+			require.Empty(t, rpcErr.ErrorString)
+			require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualRouter, &client.GetOptions{}))
+			require.Equal(t, []consulv1aplha1.ServiceRoute{adminRoute}, actualRouter.Spec.Routes)
+			require.True(t, k8serrors.IsNotFound(k8sClient.Get(context.TODO(), routesName, &consulv1aplha1.ServiceSplitter{}, &client.GetOptions{})))
+			require.True(t, k8serrors.IsNotFound(k8sClient.Get(context.TODO(), optionsName, &consulv1aplha1.ServiceRouter{}, &client.GetOptions{})))
+		})
+	}
+}
+
+func TestValidateConfigSplitHeaders(t *testing.T) {
+	err := validateConfig(ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		CanarySplitHeaders: &SplitHeaders{
+			RequestHeaders: &consulv1aplha1.HTTPHeaderModifiers{Set: map[string]string{"x-rollout-version": "{{ .Unknown }}"}},
+		},
+	})
+	require.ErrorContains(t, err, "canarySplitHeaders.requestHeaders")
+}
+
+func TestSetWeightLogFields(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	logger, hook := logtest.NewNullLogger()
+	p := &RpcPlugin{
+		K8SClient: fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build(),
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logger),
+	}
+	rpcErr := p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 20), 20, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, logrus.InfoLevel, entry.Level)
+	require.Equal(t, "default", entry.Data["namespace"])
+	require.Equal(t, "rollout", entry.Data["rollout"])
+	require.Equal(t, "SetWeight", entry.Data["method"])
+	require.Equal(t, "test-service", entry.Data["service"])
+	// The fields are added to a copy of the plugin, not to the plugin itself
+	require.Empty(t, p.LogCtx.Data)
+}
+
+// newTestRollout returns a rollout in the default namespace with a canary status for desiredWeight
+func newTestRollout(pluginConfig []byte, completed corev1.ConditionStatus, desiredWeight int32) *v1alpha1.Rollout {
+	return &v1alpha1.Rollout{
+		ObjectMeta: metav1.ObjectMeta{Name: "rollout", Namespace: "default", Generation: 10},
+		Spec: v1alpha1.RolloutSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"consul.hashicorp.com/service-meta-version": "2"},
+				},
+			},
+			Strategy: v1alpha1.RolloutStrategy{
+				Canary: &v1alpha1.CanaryStrategy{
+					TrafficRouting: &v1alpha1.RolloutTrafficRouting{
+						Plugins: map[string]json.RawMessage{ConfigKey: pluginConfig},
+					},
+				},
+			},
+		},
+		Status: v1alpha1.RolloutStatus{
+			ObservedGeneration: "10",
+			Conditions:         []v1alpha1.RolloutCondition{{Type: v1alpha1.RolloutCompleted, Status: completed}},
+			Canary: v1alpha1.CanaryStatus{
+				Weights: &v1alpha1.TrafficWeights{
+					Canary: v1alpha1.WeightDestination{Weight: desiredWeight},
+					Stable: v1alpha1.WeightDestination{Weight: 100 - desiredWeight},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PreflightCheck is the result of a check of a permission or API the plugin needs
+type PreflightCheck struct {
+	Check   string `json:"check"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// requiredAccess is a resource the plugin uses and the verbs it uses it with
+type requiredAccess struct {
+	group    string
+	resource string
+	verbs    []string
+	// consul is set for the resources in the cluster holding the Consul CRDs
+	consul bool
+}
+
+// requiredAccesses are the permissions granted by yaml/rbac.yaml, and the Rollouts the locks are checked against
+var requiredAccesses = []requiredAccess{
+	{group: consulv1aplha1.GroupVersion.Group, resource: "servicesplitters", verbs: []string{"get", "list", "watch", "create", "update", "delete"}, consul: true},
+	{group: consulv1aplha1.GroupVersion.Group, resource: "serviceresolvers", verbs: []string{"get", "list", "watch", "update"}, consul: true},
+	{group: consulv1aplha1.GroupVersion.Group, resource: "servicerouters", verbs: []string{"get", "create", "update", "delete"}, consul: true},
+	{group: consulv1aplha1.GroupVersion.Group, resource: "servicedefaults", verbs: []string{"get", "create", "update", "delete"}, consul: true},
+	{group: "", resource: "events", verbs: []string{"create", "patch"}},
+	{group: "argoproj.io", resource: "rollouts", verbs: []string{"get"}},
+	{group: "apps", resource: "deployments", verbs: []string{"get"}},
+}
+
+// requiredKinds are the kinds the plugin reads and writes, which must be served by the API server
+var requiredKinds = []struct {
+	gvk    schema.GroupVersionKind
+	consul bool
+}{
+	{gvk: consulv1aplha1.GroupVersion.WithKind("ServiceSplitter"), consul: true},
+	{gvk: consulv1aplha1.GroupVersion.WithKind("ServiceResolver"), consul: true},
+	{gvk: consulv1aplha1.GroupVersion.WithKind("ServiceRouter"), consul: true},
+	{gvk: consulv1aplha1.GroupVersion.WithKind("ServiceDefaults"), consul: true},
+	{gvk: schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}},
+}
+
+// maxUnsyncedListed is the number of config entries named when some have not synced with Consul
+const maxUnsyncedListed = 3
+
+// Preflight checks that the plugin is allowed everything it does, that the API server serves the CRDs it uses, and
+// that the Consul controller has synced the existing config entries. Permissions are checked in the allowed
+// namespaces, or cluster-wide if every namespace is allowed.
+func (r *RpcPlugin) Preflight(ctx context.Context) []PreflightCheck {
+	localClient := r.LocalClient
+	if localClient == nil {
+		localClient = r.K8SClient
+	}
+	clusterClient := func(consul bool) client.Client {
+		if consul {
+			return r.K8SClient
+		}
+		return localClient
+	}
+
+	namespaces := []string{corev1.NamespaceAll}
+	if r.Settings != nil && len(r.Settings.AllowedNamespaces) > 0 {
+		namespaces = r.Settings.AllowedNamespaces
+	}
+	var checks []PreflightCheck
+	for _, access := range requiredAccesses {
+		for _, namespace := range namespaces {
+			checks = append(checks, checkAccess(ctx, clusterClient(access.consul), access, namespace))
+		}
+	}
+	if r.SettingsConfigMap != "" {
+		namespace, _, _ := strings.Cut(r.SettingsConfigMap, "/")
+		checks = append(checks, checkAccess(ctx, localClient, requiredAccess{resource: "configmaps", verbs: []string{"get"}}, namespace))
+	}
+
+	consulKindsServed := true
+	for _, kind := range requiredKinds {
+		check := PreflightCheck{Check: fmt.Sprintf("API %s %s", kind.gvk.GroupVersion(), kind.gvk.Kind), Passed: true}
+		if _, err := clusterClient(kind.consul).RESTMapper().RESTMapping(kind.gvk.GroupKind(), kind.gvk.Version); err != nil {
+			check.Passed = false
+			check.Message = fmt.Sprintf("not served by the API server, check that the CRD is installed: %s", err)
+			if kind.consul {
+				consulKindsServed = false
+			}
+		}
+		checks = append(checks, check)
+	}
+	if consulKindsServed {
+		checks = append(checks, r.checkConfigEntriesSynced(ctx, namespaces))
+	}
+	return checks
+}
+
+// checkAccess reviews whether the plugin may use the resource with every required verb
+func checkAccess(ctx context.Context, c client.Client, access requiredAccess, namespace string) PreflightCheck {
+	resource := access.resource
+	if access.group != "" {
+		resource += "." + access.group
+	}
+	check := PreflightCheck{Check: "RBAC " + resource}
+	if namespace != corev1.NamespaceAll {
+		check.Check += " in namespace " + namespace
+	}
+	var denied []string
+	for _, verb := range access.verbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Group:     access.group,
+					Resource:  access.resource,
+				},
+			},
+		}
+		if err := c.Create(ctx, review); err != nil {
+			check.Message = fmt.Sprintf("access could not be reviewed: %s", err)
+			return check
+		}
+		if !review.Status.Allowed {
+			denied = append(denied, verb)
+		}
+	}
+	if len(denied) > 0 {
+		check.Message = fmt.Sprintf("%s denied, add them to the ClusterRole of the plugin", strings.Join(denied, ", "))
+		return check
+	}
+	check.Passed = true
+	return check
+}
+
+// checkConfigEntriesSynced checks that the Consul controller has synced the service splitters and resolvers, which
+// SetWeight requires before it changes them
+func (r *RpcPlugin) checkConfigEntriesSynced(ctx context.Context, namespaces []string) PreflightCheck {
+	check := PreflightCheck{Check: "Consul config entries synced"}
+	var total int
+	var unsynced []string
+	for _, namespace := range namespaces {
+		splitters := &consulv1aplha1.ServiceSplitterList{}
+		resolvers := &consulv1aplha1.ServiceResolverList{}
+		for _, list := range []client.ObjectList{splitters, resolvers} {
+			if err := r.K8SClient.List(ctx, list, client.InNamespace(namespace)); err != nil {
+				check.Message = fmt.Sprintf("config entries could not be listed: %s", err)
+				return check
+			}
+		}
+		for _, splitter := range splitters.Items {
+			total++
+			if splitter.SyncedConditionStatus() != corev1.ConditionTrue {
+				unsynced = append(unsynced, fmt.Sprintf("ServiceSplitter %s/%s", splitter.Namespace, splitter.Name))
+			}
+		}
+		for _, resolver := range resolvers.Items {
+			total++
+			if resolver.SyncedConditionStatus() != corev1.ConditionTrue {
+				unsynced = append(unsynced, fmt.Sprintf("ServiceResolver %s/%s", resolver.Namespace, resolver.Name))
+			}
+		}
+	}
+	if len(unsynced) > 0 {
+		listed := unsynced
+		if len(listed) > maxUnsyncedListed {
+			listed = append(listed[:maxUnsyncedListed:maxUnsyncedListed], "...")
+		}
+		check.Message = fmt.Sprintf("%d of %d have not synced with Consul, check that the Consul controller is running: %s", len(unsynced), total, strings.Join(listed, ", "))
+		return check
+	}
+	check.Passed = true
+	check.Message = fmt.Sprintf("%d service splitters and resolvers synced", total)
+	return check
+}
+
+// logPreflight runs the preflight checks and logs them in a single entry, listing whether each passed. The plugin
+// still starts when some failed, as it may work for the rollouts that do not need the missing permissions.
+func (r *RpcPlugin) logPreflight(ctx context.Context) {
+	checks := r.Preflight(ctx)
+	passed := 0
+	for _, check := range checks {
+		if check.Passed {
+			passed++
+		}
+	}
+	entry := r.LogCtx.WithFields(logrus.Fields{"checks": preflightSummary(checks)})
+	message := fmt.Sprintf("Preflight checks: %d of %d passed", passed, len(checks))
+	if passed < len(checks) {
+		entry.Warn(message)
+		return
+	}
+	entry.Info(message)
+}
+
+// preflightSummary lists each check with pass or fail, and the reason of the failed ones
+func preflightSummary(checks []PreflightCheck) string {
+	results := make([]string, 0, len(checks))
+	for _, check := range checks {
+		if check.Passed {
+			results = append(results, check.Check+": pass")
+		} else {
+			results = append(results, fmt.Sprintf("%s: fail (%s)", check.Check, check.Message))
+		}
+	}
+	return strings.Join(results, "; ")
+}
@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// reviewAccess answers SelfSubjectAccessReviews, denying the verbs listed for a resource
+func reviewAccess(denied map[string][]string) interceptor.Funcs {
+	return interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			review, ok := obj.(*authorizationv1.SelfSubjectAccessReview)
+			if !ok {
+				return c.Create(ctx, obj, opts...)
+			}
+			attributes := review.Spec.ResourceAttributes
+			review.Status.Allowed = true
+			for _, verb := range denied[attributes.Resource] {
+				if verb == attributes.Verb {
+					review.Status.Allowed = false
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// schemeRESTMapper maps every kind of the scheme, like an API server serving their CRDs
+func schemeRESTMapper(s *runtime.Scheme) meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for gvk := range s.AllKnownTypes() {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	return mapper
+}
+
+func TestPreflight(t *testing.T) {
+	testCases := map[string]struct {
+		denied          map[string][]string
+		withoutRollouts bool
+		unsynced        bool
+		settings        *PluginSettings
+		expectedFailed  map[string]string
+		expectedChecks  int
+	}{
+		"all checks pass": {
+			expectedChecks: 13,
+		},
+		"missing permissions": {
+			denied: map[string][]string{"servicesplitters": {"list", "watch"}, "events": {"create"}},
+			expectedFailed: map[string]string{
+				"RBAC servicesplitters.consul.hashicorp.com": "list, watch denied, add them to the ClusterRole of the plugin",
+				"RBAC events": "create denied, add them to the ClusterRole of the plugin",
+			},
+			expectedChecks: 13,
+		},
+		"permissions checked in the allowed namespaces": {
+			settings:       &PluginSettings{AllowedNamespaces: []string{"default", "team"}},
+			denied:         map[string][]string{"rollouts": {"get"}},
+			expectedChecks: 20,
+			expectedFailed: map[string]string{
+				"RBAC rollouts.argoproj.io in namespace default": "get denied, add them to the ClusterRole of the plugin",
+				"RBAC rollouts.argoproj.io in namespace team":    "get denied, add them to the ClusterRole of the plugin",
+			},
+		},
+		"rollouts CRD not served": {
+			withoutRollouts: true,
+			expectedChecks:  13,
+			expectedFailed: map[string]string{
+				"API argoproj.io/v1alpha1 Rollout": `not served by the API server, check that the CRD is installed: no matches for kind "Rollout" in version "argoproj.io/v1alpha1"`,
+			},
+		},
+		"config entries not synced": {
+			unsynced:       true,
+			expectedChecks: 13,
+			expectedFailed: map[string]string{
+				"Consul config entries synced": "1 of 2 have not synced with Consul, check that the Consul controller is running: ServiceResolver default/test-service",
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			require.NoError(t, authorizationv1.AddToScheme(s))
+			if !testCase.withoutRollouts {
+				require.NoError(t, v1alpha1.AddToScheme(s))
+			}
+			resolver := defaultResolver()
+			if testCase.unsynced {
+				resolver.Status.Conditions[0].Status = corev1.ConditionFalse
+			}
+			p := &RpcPlugin{
+				K8SClient: fake.NewClientBuilder().WithScheme(s).WithRESTMapper(schemeRESTMapper(s)).WithObjects(defaultSplitter(), resolver).WithInterceptorFuncs(reviewAccess(testCase.denied)).Build(),
+				LogCtx:    logrus.NewEntry(logrus.New()),
+				Settings:  testCase.settings,
+			}
+
+			checks := p.Preflight(context.TODO())
+			require.Len(t, checks, testCase.expectedChecks)
+			failed := map[string]string{}
+			for _, check := range checks {
+				if !check.Passed {
+					failed[check.Check] = check.Message
+				}
+			}
+			if testCase.expectedFailed == nil {
+				testCase.expectedFailed = map[string]string{}
+			}
+			require.Equal(t, testCase.expectedFailed, failed)
+		})
+	}
+}
+
+func TestLogPreflight(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	require.NoError(t, authorizationv1.AddToScheme(s))
+	logger, hook := logtest.NewNullLogger()
+	p := &RpcPlugin{
+		K8SClient: fake.NewClientBuilder().WithScheme(s).WithRESTMapper(schemeRESTMapper(s)).WithObjects(defaultSplitter(), defaultResolver()).WithInterceptorFuncs(reviewAccess(map[string][]string{"events": {"create"}})).Build(),
+		LogCtx:    logrus.NewEntry(logger),
+	}
+
+	p.logPreflight(context.TODO())
+	require.Len(t, hook.AllEntries(), 1)
+	entry := hook.LastEntry()
+	require.Equal(t, logrus.WarnLevel, entry.Level)
+	require.Equal(t, "Preflight checks: 11 of 13 passed", entry.Message)
+	summary := entry.Data["checks"].(string)
+	require.Contains(t, summary, "RBAC servicesplitters.consul.hashicorp.com: pass; ")
+	require.Contains(t, summary, "RBAC events: fail (create denied, add them to the ClusterRole of the plugin); ")
+	require.Contains(t, summary, `API argoproj.io/v1alpha1 Rollout: fail (not served by the API server`)
+	require.Contains(t, summary, "Consul config entries synced: pass")
+}
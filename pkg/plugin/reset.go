@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reset returns the Consul routing of a rollout to its stable version without the rollouts controller. It runs the
+// abort handling of SetWeight, which sends all traffic to the stable subset, makes the canary subset idle and releases
+// the lock, taking it from any other rollout holding it, and then removes the routes managed by the plugin. With
+// dryRun the changes are logged instead of written.
+func (r *RpcPlugin) Reset(ctx context.Context, namespace, name string, dryRun bool) error {
+	rollout := &v1alpha1.Rollout{}
+	if err := r.rolloutReader().Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, rollout, &client.GetOptions{}); err != nil {
+		return err
+	}
+
+	reset := *r
+	reset.idleCanaryOnAbort = true
+	if dryRun {
+		settings := PluginSettings{}
+		if r.Settings != nil {
+			settings = *r.Settings
+		}
+		settings.DryRun = true
+		reset.Settings = &settings
+	}
+	aborted := abortedRollout(rollout)
+	if rpcErr := reset.SetWeight(aborted, 0, []v1alpha1.WeightDestination{}); rpcErr.HasError() {
+		return rpcErr
+	}
+	if rpcErr := reset.RemoveManagedRoutes(aborted); rpcErr.HasError() {
+		return rpcErr
+	}
+	return nil
+}
+
+// abortedRollout returns a copy of the rollout as SetWeight sees it after an abort, annotated to take the lock
+func abortedRollout(rollout *v1alpha1.Rollout) *v1alpha1.Rollout {
+	aborted := rollout.DeepCopy()
+	annotations := aborted.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[takeLockAnnotation] = "true"
+	aborted.SetAnnotations(annotations)
+	aborted.Status.Abort = true
+	aborted.Status.Canary.Weights = &v1alpha1.TrafficWeights{
+		Canary: v1alpha1.WeightDestination{Weight: 0},
+		Stable: v1alpha1.WeightDestination{Weight: 100},
+	}
+	return aborted
+}
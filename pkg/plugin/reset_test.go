@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReset(t *testing.T) {
+	managed := consulv1aplha1.ServiceRoute{
+		Match: &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+			Header: []consulv1aplha1.ServiceRouteHTTPMatchHeader{{Name: "x-canary", Exact: "abc123-1"}},
+		}},
+		Destination: &consulv1aplha1.ServiceRouteDestination{ServiceSubset: "canary"},
+	}
+	encoded, err := json.Marshal([]consulv1aplha1.ServiceRoute{managed})
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		dryRun                    bool
+		expectedWeights           []float32
+		expectedCanaryFilter      string
+		expectedLock              string
+		expectedRoutes            []consulv1aplha1.ServiceRoute
+		expectedManagedAnnotation bool
+	}{
+		"reset to stable": {
+			expectedWeights:      []float32{100, 0},
+			expectedCanaryFilter: idleCanaryFilter,
+			expectedRoutes:       nil,
+		},
+		"dry run": {
+			dryRun:                    true,
+			expectedWeights:           []float32{70, 30},
+			expectedCanaryFilter:      `Service.Meta.version == "2"`,
+			expectedLock:              `{"rollout":"other","uid":"other-uid"}`,
+			expectedRoutes:            []consulv1aplha1.ServiceRoute{managed},
+			expectedManagedAnnotation: true,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			require.NoError(t, v1alpha1.AddToScheme(s))
+			splitter := defaultSplitter()
+			splitter.Spec.Splits[0].Weight = 70
+			splitter.Spec.Splits[1].Weight = 30
+			splitter.Annotations = map[string]string{lockAnnotation: `{"rollout":"other","uid":"other-uid"}`}
+			resolver := defaultResolver()
+			resolver.Spec.Subsets["canary"] = consulv1aplha1.ServiceResolverSubset{Filter: `Service.Meta.version == "2"`}
+			router := &consulv1aplha1.ServiceRouter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-service",
+					Namespace:   "default",
+					Annotations: map[string]string{managedRoutesAnnotation: string(encoded)},
+				},
+				Spec: consulv1aplha1.ServiceRouterSpec{Routes: []consulv1aplha1.ServiceRoute{managed}},
+			}
+			rollout := newTestRollout(pluginJsonWithStickyHeader("x-canary"), corev1.ConditionFalse, 30)
+			otherRollout := &v1alpha1.Rollout{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "other-uid"}}
+			k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(splitter, resolver, router, rollout, otherRollout).Build()
+			p := &RpcPlugin{
+				K8SClient: k8sClient,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+
+			require.NoError(t, p.Reset(context.TODO(), "default", "rollout", testCase.dryRun))
+
+			key := types.NamespacedName{Name: "test-service", Namespace: "default"}
+			actualSplitter := &consulv1aplha1.ServiceSplitter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), key, actualSplitter, &client.GetOptions{}))
+			require.Equal(t, testCase.expectedWeights, []float32{actualSplitter.Spec.Splits[0].Weight, actualSplitter.Spec.Splits[1].Weight})
+			require.Equal(t, testCase.expectedLock, actualSplitter.Annotations[lockAnnotation])
+			actualResolver := &consulv1aplha1.ServiceResolver{}
+			require.NoError(t, k8sClient.Get(context.TODO(), key, actualResolver, &client.GetOptions{}))
+			require.Equal(t, testCase.expectedCanaryFilter, actualResolver.Spec.Subsets["canary"].Filter)
+			actualRouter := &consulv1aplha1.ServiceRouter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), key, actualRouter, &client.GetOptions{}))
+			require.Equal(t, testCase.expectedRoutes, actualRouter.Spec.Routes)
+			require.Equal(t, testCase.expectedManagedAnnotation, actualRouter.Annotations[managedRoutesAnnotation] != "")
+		})
+	}
+}
+
+func TestResetAfterSnapshot(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	require.NoError(t, v1alpha1.AddToScheme(s))
+	// The canary filter recorded in the snapshot still selects the previous canary version
+	resolver := defaultResolver()
+	resolver.Spec.Subsets["canary"] = consulv1aplha1.ServiceResolverSubset{Filter: `Service.Meta.version == "1"`}
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 30)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "3"}
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultSplitter(), resolver, rollout).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+
+	rpcErr := p.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	key := types.NamespacedName{Name: "test-service", Namespace: "default"}
+	actualResolver := &consulv1aplha1.ServiceResolver{}
+	require.NoError(t, k8sClient.Get(context.TODO(), key, actualResolver, &client.GetOptions{}))
+	require.Contains(t, actualResolver.Annotations, snapshotAnnotation)
+
+	require.NoError(t, p.Reset(context.TODO(), "default", "rollout", false))
+
+	require.NoError(t, k8sClient.Get(context.TODO(), key, actualResolver, &client.GetOptions{}))
+	require.Equal(t, idleCanaryFilter, actualResolver.Spec.Subsets["canary"].Filter)
+	require.Equal(t, resolver.Spec.Subsets["stable"], actualResolver.Spec.Subsets["stable"])
+	actualSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), key, actualSplitter, &client.GetOptions{}))
+	require.Equal(t, defaultSplitter().Spec, actualSplitter.Spec)
+}
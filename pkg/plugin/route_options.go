@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// canaryOptionsServiceSuffix is appended to the service name to name the virtual service whose router applies the
+// canary route options
+const canaryOptionsServiceSuffix = "-canary-options"
+
+// CanaryRouteOptions are the timeout and retry settings applied to the requests sent to the canary subset while a
+// canary is in progress. Consul applies these settings per ServiceRouter route, and service splits cannot carry them, so
+// the canary share of the split is sent to a virtual service whose router carries the options to the canary subset.
+type CanaryRouteOptions struct {
+	RequestTimeout        metav1.Duration `json:"requestTimeout,omitempty" protobuf:"bytes,1,opt,name=requestTimeout"`
+	NumRetries            uint32          `json:"numRetries,omitempty" protobuf:"varint,2,opt,name=numRetries"`
+	RetryOnConnectFailure bool            `json:"retryOnConnectFailure,omitempty" protobuf:"varint,3,opt,name=retryOnConnectFailure"`
+	RetryOnStatusCodes    []uint32        `json:"retryOnStatusCodes,omitempty" protobuf:"varint,4,rep,name=retryOnStatusCodes"`
+}
+
+func validateCanaryRouteOptions(cfg ConsulTrafficRouting) error {
+	opts := cfg.CanaryRouteOptions
+	if opts == nil {
+		return nil
+	}
+	if opts.RequestTimeout.Duration < 0 {
+		return errors.New("invalid consul traffic routing configuration. canaryRouteOptions.requestTimeout must not be negative")
+	}
+	for _, code := range opts.RetryOnStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid consul traffic routing configuration. canaryRouteOptions.retryOnStatusCodes contains invalid status code %d", code)
+		}
+	}
+	return nil
+}
+
+// canaryOptionsServiceName returns the name of the virtual service whose router applies the options to the canary
+func (c *ConsulTrafficRouting) canaryOptionsServiceName() string {
+	return c.ServiceName + canaryOptionsServiceSuffix
+}
+
+// canaryRouteMatches returns the requests divided by the canary routes splitter. With route options but no canary
+// routes every request is, so that the canary share of all requests passes through the canary options virtual service.
+func (c *ConsulTrafficRouting) canaryRouteMatches() []CanaryRouteMatch {
+	if len(c.CanaryRoutes) == 0 && c.CanaryRouteOptions != nil {
+		return []CanaryRouteMatch{{PathPrefix: "/"}}
+	}
+	return c.CanaryRoutes
+}
+
+// isCatchAllRoute reports whether the route matches every request
+func isCatchAllRoute(route consulv1aplha1.ServiceRoute) bool {
+	return route.Match != nil && route.Match.HTTP != nil && reflect.DeepEqual(*route.Match.HTTP, consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/"})
+}
+
+// withCanaryRouteOptions sets the options on a route to the canary subset
+func withCanaryRouteOptions(route consulv1aplha1.ServiceRoute, opts *CanaryRouteOptions) consulv1aplha1.ServiceRoute {
+	if opts == nil {
+		return route
+	}
+	if route.Destination == nil {
+		route.Destination = &consulv1aplha1.ServiceRouteDestination{}
+	}
+	route.Destination.RequestTimeout = opts.RequestTimeout
+	route.Destination.NumRetries = opts.NumRetries
+	route.Destination.RetryOnConnectFailure = opts.RetryOnConnectFailure
+	route.Destination.RetryOnStatusCodes = opts.RetryOnStatusCodes
+	return route
+}
+
+// canaryOptionsRoute returns the route of the canary options virtual service, sending all its requests to the canary
+// subset of the service with the options
+func canaryOptionsRoute(cfg *ConsulTrafficRouting) consulv1aplha1.ServiceRoute {
+	return withCanaryRouteOptions(consulv1aplha1.ServiceRoute{
+		Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/"}},
+		Destination: &consulv1aplha1.ServiceRouteDestination{Service: cfg.ServiceName, ServiceSubset: cfg.CanarySubsetName},
+	}, cfg.CanaryRouteOptions)
+}
+
+// reconcileCanaryOptionsRouter writes the route of the plugin-managed ServiceRouter of the canary options virtual
+// service, creating it if needed. When route is nil a router created by the plugin is deleted.
+func (r *RpcPlugin) reconcileCanaryOptionsRouter(ctx context.Context, namespace, name string, route *consulv1aplha1.ServiceRoute) error {
+	serviceRouter := &consulv1aplha1.ServiceRouter{}
+	err := r.K8SClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, serviceRouter, &client.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		if route == nil {
+			return nil
+		}
+		serviceRouter = &consulv1aplha1.ServiceRouter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{createdByPluginAnnotation: "true"},
+			},
+			Spec: consulv1aplha1.ServiceRouterSpec{Routes: []consulv1aplha1.ServiceRoute{*route}},
+		}
+		return r.K8SClient.Create(ctx, serviceRouter, &client.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if serviceRouter.Annotations[createdByPluginAnnotation] != "true" {
+		return fmt.Errorf("service router %s already exists and was not created by the plugin, it is needed to apply canaryRouteOptions", name)
+	}
+	if route == nil {
+		return r.K8SClient.Delete(ctx, serviceRouter, &client.DeleteOptions{})
+	}
+	if err := validateRouterSyncStatus(serviceRouter, r.Settings.syncTimeout()); err != nil {
+		return err
+	}
+	routes := []consulv1aplha1.ServiceRoute{*route}
+	if reflect.DeepEqual(serviceRouter.Spec.Routes, routes) {
+		return nil
+	}
+	serviceRouter.Spec.Routes = routes
+	r.LogCtx.WithField("serviceRouter", serviceRouter).Debug("Updating canary options ServiceRouter")
+	return r.K8SClient.Update(ctx, serviceRouter, &client.UpdateOptions{})
+}
@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// managedRoutesAnnotation records the routes on a ServiceRouter that were written by the plugin, so that they can be
+	// replaced or removed without touching routes authored by hand
+	managedRoutesAnnotation = "argo-rollouts.argoproj.io/consul-managed-routes"
+	// createdByPluginAnnotation marks a config entry that was created by the plugin, so that it can be deleted once the
+	// plugin no longer needs it
+	createdByPluginAnnotation = "argo-rollouts.argoproj.io/consul-created"
+)
+
+// reconcileManagedRoutes replaces the routes previously written by the plugin on the ServiceRouter with desiredRoutes.
+// Managed routes are placed ahead of any other routes so that they take precedence, except a catch-all route. If the
+// ServiceRouter does not exist it is created, and if it was created by the plugin and no routes remain it is deleted.
+func (r *RpcPlugin) reconcileManagedRoutes(ctx context.Context, namespace, serviceName string, desiredRoutes []consulv1aplha1.ServiceRoute) error {
+	serviceRouter := &consulv1aplha1.ServiceRouter{}
+	err := r.K8SClient.Get(ctx, types.NamespacedName{Name: serviceName, Namespace: namespace}, serviceRouter, &client.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		if len(desiredRoutes) == 0 {
+			return nil
+		}
+		serviceRouter = &consulv1aplha1.ServiceRouter{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        serviceName,
+				Namespace:   namespace,
+				Annotations: map[string]string{createdByPluginAnnotation: "true"},
+			},
+		}
+		if err := setManagedRoutes(serviceRouter, desiredRoutes, nil); err != nil {
+			return err
+		}
+		return r.K8SClient.Create(ctx, serviceRouter, &client.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := validateRouterSyncStatus(serviceRouter, r.Settings.syncTimeout()); err != nil {
+		return err
+	}
+
+	currentManaged, err := managedRoutes(serviceRouter)
+	if err != nil {
+		return err
+	}
+	unmanaged := withoutRoutes(serviceRouter.Spec.Routes, currentManaged)
+
+	if len(desiredRoutes) == 0 && len(unmanaged) == 0 && serviceRouter.Annotations[createdByPluginAnnotation] == "true" {
+		return r.K8SClient.Delete(ctx, serviceRouter, &client.DeleteOptions{})
+	}
+
+	before := serviceRouter.DeepCopy()
+	if err := setManagedRoutes(serviceRouter, desiredRoutes, unmanaged); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(before.Spec, serviceRouter.Spec) && reflect.DeepEqual(before.Annotations, serviceRouter.Annotations) {
+		return nil
+	}
+	r.LogCtx.WithField("serviceRouter", serviceRouter).Debug("Updating ServiceRouter")
+	return r.K8SClient.Update(ctx, serviceRouter, &client.UpdateOptions{})
+}
+
+// setManagedRoutes writes the managed routes ahead of the unmanaged routes and records them in the annotation. A
+// managed catch-all route is written last instead, so that it does not shadow the unmanaged routes.
+func setManagedRoutes(sr *consulv1aplha1.ServiceRouter, managed, unmanaged []consulv1aplha1.ServiceRoute) error {
+	routes := make([]consulv1aplha1.ServiceRoute, 0, len(managed)+len(unmanaged))
+	var catchAll []consulv1aplha1.ServiceRoute
+	for _, route := range managed {
+		if isCatchAllRoute(route) {
+			catchAll = append(catchAll, route)
+		} else {
+			routes = append(routes, route)
+		}
+	}
+	routes = append(routes, unmanaged...)
+	routes = append(routes, catchAll...)
+	sr.Spec.Routes = routes
+
+	if sr.Annotations == nil {
+		sr.Annotations = map[string]string{}
+	}
+	if len(managed) == 0 {
+		delete(sr.Annotations, managedRoutesAnnotation)
+		return nil
+	}
+	encoded, err := json.Marshal(managed)
+	if err != nil {
+		return err
+	}
+	sr.Annotations[managedRoutesAnnotation] = string(encoded)
+	return nil
+}
+
+// managedRoutes returns the routes recorded as written by the plugin
+func managedRoutes(sr *consulv1aplha1.ServiceRouter) ([]consulv1aplha1.ServiceRoute, error) {
+	encoded, ok := sr.Annotations[managedRoutesAnnotation]
+	if !ok || encoded == "" {
+		return nil, nil
+	}
+	var routes []consulv1aplha1.ServiceRoute
+	if err := json.Unmarshal([]byte(encoded), &routes); err != nil {
+		return nil, errors.New("annotation " + managedRoutesAnnotation + " on service router could not be parsed: " + err.Error())
+	}
+	return routes, nil
+}
+
+// withoutRoutes returns routes with every entry of remove taken out
+func withoutRoutes(routes, remove []consulv1aplha1.ServiceRoute) []consulv1aplha1.ServiceRoute {
+	result := []consulv1aplha1.ServiceRoute{}
+	for _, route := range routes {
+		managed := false
+		for _, m := range remove {
+			if reflect.DeepEqual(route, m) {
+				managed = true
+				break
+			}
+		}
+		if !managed {
+			result = append(result, route)
+		}
+	}
+	return result
+}
+
+// validateRouterSyncStatus checks if the router has synced with Consul, this is necessary to ensure that the router
+// is up-to-date before the rollout can continue
+func validateRouterSyncStatus(router *consulv1aplha1.ServiceRouter, syncTimeout time.Duration) error {
+	for _, condition := range router.Status.Conditions {
+		if condition.Type == consulv1aplha1.ConditionSynced {
+			if condition.Status != corev1.ConditionTrue || exceedsSyncTimeout(condition.LastTransitionTime.Time, router.LastSyncedTime.Time, syncTimeout) {
+				return errors.New("service router has not synced with Consul. The service router needs to be up to date before rollout can continue")
+			}
+		}
+	}
+	return nil
+}
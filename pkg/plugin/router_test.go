@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRemoveManagedRoutes(t *testing.T) {
+	managed := consulv1aplha1.ServiceRoute{
+		Match: &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+			Header: []consulv1aplha1.ServiceRouteHTTPMatchHeader{{Name: "x-canary", Exact: "abc123-1"}},
+		}},
+		Destination: &consulv1aplha1.ServiceRouteDestination{ServiceSubset: "canary"},
+	}
+	unmanaged := consulv1aplha1.ServiceRoute{
+		Match:       &consulv1aplha1.ServiceRouteMatch{HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{PathPrefix: "/admin"}},
+		Destination: &consulv1aplha1.ServiceRouteDestination{Service: "admin"},
+	}
+	encoded, err := json.Marshal([]consulv1aplha1.ServiceRoute{managed})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		testName       string
+		pluginConfig   []byte
+		inputRouter    *consulv1aplha1.ServiceRouter
+		expectedRoutes []consulv1aplha1.ServiceRoute
+	}{
+		{
+			testName:     "removes managed routes and keeps others",
+			pluginConfig: pluginJsonWithStickyHeader("x-canary"),
+			inputRouter: &consulv1aplha1.ServiceRouter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-service",
+					Namespace:   "default",
+					Annotations: map[string]string{managedRoutesAnnotation: string(encoded)},
+				},
+				Spec: consulv1aplha1.ServiceRouterSpec{Routes: []consulv1aplha1.ServiceRoute{managed, unmanaged}},
+			},
+			expectedRoutes: []consulv1aplha1.ServiceRoute{unmanaged},
+		},
+		{
+			testName:     "does not touch router when routes are not managed",
+			pluginConfig: pluginJson(),
+			inputRouter: &consulv1aplha1.ServiceRouter{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-service",
+					Namespace:   "default",
+					Annotations: map[string]string{managedRoutesAnnotation: string(encoded)},
+				},
+				Spec: consulv1aplha1.ServiceRouterSpec{Routes: []consulv1aplha1.ServiceRoute{managed, unmanaged}},
+			},
+			expectedRoutes: []consulv1aplha1.ServiceRoute{managed, unmanaged},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.testName, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(testCase.inputRouter).Build()
+			p := &RpcPlugin{
+				K8SClient: k8sClient,
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+			rollout := &v1alpha1.Rollout{
+				ObjectMeta: metav1.ObjectMeta{Name: "rollout", Namespace: "default"},
+				Spec: v1alpha1.RolloutSpec{
+					Strategy: v1alpha1.RolloutStrategy{
+						Canary: &v1alpha1.CanaryStrategy{
+							TrafficRouting: &v1alpha1.RolloutTrafficRouting{
+								Plugins: map[string]json.RawMessage{ConfigKey: testCase.pluginConfig},
+							},
+						},
+					},
+				},
+			}
+
+			rpcErr := p.RemoveManagedRoutes(rollout)
+			require.Empty(t, rpcErr.ErrorString)
+
+			actualRouter := &consulv1aplha1.ServiceRouter{}
+			require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualRouter, &client.GetOptions{}))
+			require.Equal(t, testCase.expectedRoutes, actualRouter.Spec.Routes)
+		})
+	}
+}
+
+func pluginJsonWithStickyHeader(header string) []byte {
+	config := ConsulTrafficRouting{
+		ServiceName:      "test-service",
+		CanarySubsetName: "canary",
+		StableSubsetName: "stable",
+		StickySession:    &StickySession{HeaderName: header},
+	}
+	jsonConfig, _ := json.Marshal(config)
+	return jsonConfig
+}
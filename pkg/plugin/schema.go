@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// requiredConfigFields are the fields validateConfig requires, by the type holding them
+var requiredConfigFields = map[reflect.Type][]string{
+	reflect.TypeOf(ConsulTrafficRouting{}): {"serviceName", "canarySubsetName", "stableSubsetName"},
+	reflect.TypeOf(Mirror{}):               {"sourceServices", "canaryCluster"},
+}
+
+// ConfigSchema returns the JSON Schema of the plugin configuration of a rollout, generated from ConsulTrafficRouting
+func ConfigSchema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(ConsulTrafficRouting{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Consul traffic router plugin configuration"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// typeSchema returns the schema of the JSON encoding of t
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(metav1.Duration{}) {
+		return map[string]interface{}{"type": "string", "description": "A duration such as 1.5s or 2m"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for _, field := range jsonFields(t) {
+			properties[field.name] = typeSchema(field.Type)
+		}
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if required, ok := requiredConfigFields[t]; ok {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema, err := ConfigSchema()
+	require.NoError(t, err)
+
+	published, err := os.ReadFile("../../yaml/consul-traffic-routing.schema.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(published), string(schema), "the published schema is out of date, run make schema")
+
+	var parsed struct {
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(schema, &parsed))
+	require.Equal(t, []string{"serviceName", "canarySubsetName", "stableSubsetName"}, parsed.Required)
+	require.JSONEq(t, `{
+		"type": "object",
+		"properties": {
+			"sourceServices": {"type": "array", "items": {"type": "string"}},
+			"canaryCluster": {"type": "string"}
+		},
+		"required": ["sourceServices", "canaryCluster"],
+		"additionalProperties": false
+	}`, string(parsed.Properties["mirror"]))
+}
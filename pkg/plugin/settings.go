@@ -0,0 +1,187 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// settingsConfigMapKey is the key of the plugin settings in the settings ConfigMap
+	settingsConfigMapKey = "config.yaml"
+
+	defaultSyncTimeout = 2 * time.Second
+)
+
+// PluginSettings are the plugin-wide settings, loaded from a file or a ConfigMap at InitPlugin. The rollout
+// configuration overrides the defaults they set.
+type PluginSettings struct {
+	// ServiceMetaAnnotationSuffix is the default suffix of the service meta annotation selecting the subsets
+	ServiceMetaAnnotationSuffix string `json:"serviceMetaAnnotationSuffix,omitempty"`
+	// SyncTimeout is how far the last sync of a config entry may be from its last sync condition change. Defaults to 2s
+	SyncTimeout metav1.Duration `json:"syncTimeout,omitempty"`
+	// AllowedNamespaces restricts the namespaces of the rollouts the plugin acts on. All namespaces are allowed if empty
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// Limits are the safety limits applied to every rollout
+	Limits *SafetyLimits `json:"limits,omitempty"`
+	// DryRun logs the changes the plugin would make for every rollout instead of writing them
+	DryRun bool `json:"dryRun,omitempty"`
+	// RPCTimeout is the deadline of every RPC call, including all its Kubernetes calls. Defaults to 30s
+	RPCTimeout metav1.Duration `json:"rpcTimeout,omitempty"`
+	// DisableCache reads the Consul config entries from the API server instead of an informer cache
+	DisableCache bool `json:"disableCache,omitempty"`
+}
+
+// SafetyLimits bound the weights a rollout may set on the canary subset. A zero value does not limit.
+type SafetyLimits struct {
+	// MaxCanaryWeight is the highest weight of the canary subset before the rollout completes
+	MaxCanaryWeight int32 `json:"maxCanaryWeight,omitempty" protobuf:"varint,1,opt,name=maxCanaryWeight"`
+	// MaxWeightIncrease is the largest increase of the canary weight in a single step
+	MaxWeightIncrease int32 `json:"maxWeightIncrease,omitempty" protobuf:"varint,2,opt,name=maxWeightIncrease"`
+}
+
+// loadSettings reads the plugin settings from the configured file or ConfigMap. Without either, the defaults are used.
+func (r *RpcPlugin) loadSettings(ctx context.Context) (*PluginSettings, error) {
+	var data []byte
+	switch {
+	case r.SettingsFile != "" && r.SettingsConfigMap != "":
+		return nil, errors.New("plugin settings can be loaded from a file or a ConfigMap, not both")
+	case r.SettingsFile != "":
+		contents, err := os.ReadFile(r.SettingsFile)
+		if err != nil {
+			return nil, fmt.Errorf("plugin settings could not be read: %w", err)
+		}
+		data = contents
+	case r.SettingsConfigMap != "":
+		namespace, name, ok := strings.Cut(r.SettingsConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("plugin settings ConfigMap %q must be given as <namespace>/<name>", r.SettingsConfigMap)
+		}
+		configMap := &corev1.ConfigMap{}
+		if err := r.K8SClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, configMap, &client.GetOptions{}); err != nil {
+			return nil, fmt.Errorf("plugin settings ConfigMap %s could not be read: %w", r.SettingsConfigMap, err)
+		}
+		data = []byte(configMap.Data[settingsConfigMapKey])
+	default:
+		return &PluginSettings{}, nil
+	}
+	return parseSettings(data)
+}
+
+// parseSettings parses YAML or JSON plugin settings and validates them
+func parseSettings(data []byte) (*PluginSettings, error) {
+	settings := &PluginSettings{}
+	if err := yaml.UnmarshalStrict(data, settings); err != nil {
+		return nil, fmt.Errorf("invalid plugin settings: %w", err)
+	}
+	if settings.SyncTimeout.Duration < 0 {
+		return nil, errors.New("invalid plugin settings. syncTimeout must not be negative")
+	}
+	if settings.RPCTimeout.Duration < 0 {
+		return nil, errors.New("invalid plugin settings. rpcTimeout must not be negative")
+	}
+	if err := validateSafetyLimits("limits", settings.Limits); err != nil {
+		return nil, fmt.Errorf("invalid plugin settings. %w", err)
+	}
+	return settings, nil
+}
+
+// syncTimeout returns the configured sync timeout, or the default if none is configured
+func (s *PluginSettings) syncTimeout() time.Duration {
+	if s == nil || s.SyncTimeout.Duration == 0 {
+		return defaultSyncTimeout
+	}
+	return s.SyncTimeout.Duration
+}
+
+// namespaceAllowed reports whether the plugin may act on rollouts in namespace
+func (s *PluginSettings) namespaceAllowed(namespace string) bool {
+	if s == nil || len(s.AllowedNamespaces) == 0 {
+		return true
+	}
+	for _, allowed := range s.AllowedNamespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// applySettings fills the rollout configuration with the plugin-wide defaults and merges the safety limits. A rollout
+// may tighten the plugin-wide limits but not relax them.
+func applySettings(cfg *ConsulTrafficRouting, settings *PluginSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if cfg.ServiceMetaAnnotationSuffix == "" {
+		cfg.ServiceMetaAnnotationSuffix = settings.ServiceMetaAnnotationSuffix
+	}
+	if settings.Limits == nil {
+		return nil
+	}
+	if cfg.Limits == nil {
+		limits := *settings.Limits
+		cfg.Limits = &limits
+		return nil
+	}
+	merged, err := mergeLimit("maxCanaryWeight", cfg.Limits.MaxCanaryWeight, settings.Limits.MaxCanaryWeight)
+	if err != nil {
+		return err
+	}
+	cfg.Limits.MaxCanaryWeight = merged
+	merged, err = mergeLimit("maxWeightIncrease", cfg.Limits.MaxWeightIncrease, settings.Limits.MaxWeightIncrease)
+	if err != nil {
+		return err
+	}
+	cfg.Limits.MaxWeightIncrease = merged
+	return nil
+}
+
+func mergeLimit(name string, rolloutLimit, pluginLimit int32) (int32, error) {
+	if rolloutLimit == 0 {
+		return pluginLimit, nil
+	}
+	if pluginLimit != 0 && rolloutLimit > pluginLimit {
+		return 0, fmt.Errorf("invalid consul traffic routing configuration. limits.%s %d exceeds the plugin limit of %d", name, rolloutLimit, pluginLimit)
+	}
+	return rolloutLimit, nil
+}
+
+func validateSafetyLimits(field string, limits *SafetyLimits) error {
+	if limits == nil {
+		return nil
+	}
+	if limits.MaxCanaryWeight < 0 || limits.MaxCanaryWeight > 100 {
+		return fmt.Errorf("%s.maxCanaryWeight must be between 0 and 100", field)
+	}
+	if limits.MaxWeightIncrease < 0 || limits.MaxWeightIncrease > 100 {
+		return fmt.Errorf("%s.maxWeightIncrease must be between 0 and 100", field)
+	}
+	return nil
+}
+
+// checkSafetyLimits fails when setting the canary weight from currentWeight to desiredWeight exceeds the limits
+func checkSafetyLimits(limits *SafetyLimits, currentWeight float32, desiredWeight int32) error {
+	if limits == nil {
+		return nil
+	}
+	if limits.MaxCanaryWeight != 0 && desiredWeight > limits.MaxCanaryWeight {
+		return fmt.Errorf("refusing to set the canary weight to %d, above the limit of %d", desiredWeight, limits.MaxCanaryWeight)
+	}
+	if limits.MaxWeightIncrease != 0 && float32(desiredWeight)-currentWeight > float32(limits.MaxWeightIncrease) {
+		return fmt.Errorf("refusing to increase the canary weight from %v to %d, more than the limit of %d per step", currentWeight, desiredWeight, limits.MaxWeightIncrease)
+	}
+	return nil
+}
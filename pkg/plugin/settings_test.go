@@ -0,0 +1,186 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testSettings = `
+serviceMetaAnnotationSuffix: release
+syncTimeout: 5s
+allowedNamespaces:
+  - default
+limits:
+  maxCanaryWeight: 50
+  maxWeightIncrease: 20
+`
+
+func TestParseSettings(t *testing.T) {
+	settings, err := parseSettings([]byte(testSettings))
+	require.NoError(t, err)
+	require.Equal(t, &PluginSettings{
+		ServiceMetaAnnotationSuffix: "release",
+		SyncTimeout:                 metav1.Duration{Duration: 5 * time.Second},
+		AllowedNamespaces:           []string{"default"},
+		Limits:                      &SafetyLimits{MaxCanaryWeight: 50, MaxWeightIncrease: 20},
+	}, settings)
+
+	settings, err = parseSettings([]byte(`{"syncTimeout": "1s"}`))
+	require.NoError(t, err)
+	require.Equal(t, time.Second, settings.syncTimeout())
+
+	_, err = parseSettings([]byte(`syncTimout: 1s`))
+	require.ErrorContains(t, err, `unknown field "syncTimout"`)
+
+	_, err = parseSettings([]byte(`limits: {maxCanaryWeight: 150}`))
+	require.ErrorContains(t, err, "limits.maxCanaryWeight must be between 0 and 100")
+}
+
+func TestLoadSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "settings.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(testSettings), 0o600))
+	p := &RpcPlugin{SettingsFile: file}
+	settings, err := p.loadSettings(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, "release", settings.ServiceMetaAnnotationSuffix)
+
+	s := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(s))
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "consul-plugin", Namespace: "argo-rollouts"},
+		Data:       map[string]string{settingsConfigMapKey: testSettings},
+	}
+	p = &RpcPlugin{
+		K8SClient:         fake.NewClientBuilder().WithScheme(s).WithObjects(configMap).Build(),
+		SettingsConfigMap: "argo-rollouts/consul-plugin",
+	}
+	settings, err = p.loadSettings(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, []string{"default"}, settings.AllowedNamespaces)
+
+	p.SettingsFile = file
+	_, err = p.loadSettings(context.TODO())
+	require.ErrorContains(t, err, "not both")
+
+	settings, err = (&RpcPlugin{}).loadSettings(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, defaultSyncTimeout, settings.syncTimeout())
+}
+
+func TestGetPluginConfigWithSettings(t *testing.T) {
+	settings := &PluginSettings{
+		ServiceMetaAnnotationSuffix: "release",
+		AllowedNamespaces:           []string{"default"},
+		Limits:                      &SafetyLimits{MaxCanaryWeight: 50, MaxWeightIncrease: 20},
+	}
+
+	testCases := map[string]struct {
+		config         ConsulTrafficRouting
+		namespace      string
+		expectedSuffix string
+		expectedLimits *SafetyLimits
+		expectedError  string
+	}{
+		"defaults from settings": {
+			config:         ConsulTrafficRouting{ServiceName: "test-service", CanarySubsetName: "canary", StableSubsetName: "stable"},
+			namespace:      "default",
+			expectedSuffix: "release",
+			expectedLimits: &SafetyLimits{MaxCanaryWeight: 50, MaxWeightIncrease: 20},
+		},
+		"rollout overrides suffix and tightens limits": {
+			config: ConsulTrafficRouting{
+				ServiceName: "test-service", CanarySubsetName: "canary", StableSubsetName: "stable",
+				ServiceMetaAnnotationSuffix: "version",
+				Limits:                      &SafetyLimits{MaxWeightIncrease: 10},
+			},
+			namespace:      "default",
+			expectedSuffix: "version",
+			expectedLimits: &SafetyLimits{MaxCanaryWeight: 50, MaxWeightIncrease: 10},
+		},
+		"rollout relaxes limits": {
+			config: ConsulTrafficRouting{
+				ServiceName: "test-service", CanarySubsetName: "canary", StableSubsetName: "stable",
+				Limits: &SafetyLimits{MaxCanaryWeight: 80},
+			},
+			namespace:     "default",
+			expectedError: "invalid consul traffic routing configuration. limits.maxCanaryWeight 80 exceeds the plugin limit of 50",
+		},
+		"namespace not allowed": {
+			config:        ConsulTrafficRouting{ServiceName: "test-service", CanarySubsetName: "canary", StableSubsetName: "stable"},
+			namespace:     "other",
+			expectedError: "namespace other is not one of the allowed namespaces of the plugin",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pluginConfig, err := json.Marshal(testCase.config)
+			require.NoError(t, err)
+			rollout := newTestRollout(pluginConfig, corev1.ConditionFalse, 0)
+			rollout.Namespace = testCase.namespace
+
+			cfg, err := getPluginConfig(rollout, settings)
+			if testCase.expectedError != "" {
+				require.EqualError(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedSuffix, cfg.ServiceMetaAnnotationSuffix)
+			require.Equal(t, testCase.expectedLimits, cfg.Limits)
+		})
+	}
+}
+
+func TestSetWeightSafetyLimits(t *testing.T) {
+	testCases := map[string]struct {
+		desiredWeight int32
+		stepIndex     int32
+		expectedError string
+	}{
+		"within limits":           {desiredWeight: 20},
+		"above max canary weight": {desiredWeight: 60, expectedError: "refusing to set the canary weight to 60, above the limit of 50"},
+		"above max increase":      {desiredWeight: 30, expectedError: "refusing to increase the canary weight from 0 to 30, more than the limit of 20 per step"},
+		"promotion after the last step": {
+			desiredWeight: 100,
+			stepIndex:     2,
+		},
+		"step setting 100": {
+			desiredWeight: 100,
+			stepIndex:     1,
+			expectedError: "refusing to set the canary weight to 100, above the limit of 50",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			p := &RpcPlugin{
+				K8SClient: fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build(),
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+				Settings:  &PluginSettings{Limits: &SafetyLimits{MaxCanaryWeight: 50, MaxWeightIncrease: 20}},
+			}
+			rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, testCase.desiredWeight)
+			firstWeight, secondWeight := int32(20), int32(40)
+			rollout.Spec.Strategy.Canary.Steps = []v1alpha1.CanaryStep{{SetWeight: &firstWeight}, {SetWeight: &secondWeight}}
+			rollout.Status.CurrentStepIndex = &testCase.stepIndex
+			rpcErr := p.SetWeight(rollout, testCase.desiredWeight, []v1alpha1.WeightDestination{})
+			require.Equal(t, testCase.expectedError, rpcErr.ErrorString)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// snapshotAnnotation records the spec of a config entry before the plugin first modified it for a rollout revision,
+// so that an abort can restore it exactly
+const snapshotAnnotation = "argo-rollouts.argoproj.io/consul-snapshot"
+
+// restoredAnnotation records the rollout revision whose snapshot was restored on abort. The snapshot is removed once
+// restored, and later calls for the aborted revision leave the restored spec as it is.
+const restoredAnnotation = "argo-rollouts.argoproj.io/consul-restored"
+
+type specSnapshot struct {
+	Revision string          `json:"revision"`
+	Spec     json.RawMessage `json:"spec"`
+}
+
+// recordSnapshot stores spec in the annotation of obj, unless a snapshot of the revision is already stored
+func recordSnapshot(obj metav1.Object, revision string, spec interface{}) error {
+	if existing, err := readSnapshot(obj); err != nil {
+		return err
+	} else if existing != nil && existing.Revision == revision {
+		return nil
+	}
+	encodedSpec, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(specSnapshot{Revision: revision, Spec: encodedSpec})
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[snapshotAnnotation] = string(encoded)
+	delete(annotations, restoredAnnotation)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// restoreSnapshot decodes the snapshot of the revision into spec, and reports whether one was found
+func restoreSnapshot(obj metav1.Object, revision string, spec interface{}) (bool, error) {
+	snapshot, err := readSnapshot(obj)
+	if err != nil || snapshot == nil || snapshot.Revision != revision {
+		return false, err
+	}
+	if err := json.Unmarshal(snapshot.Spec, spec); err != nil {
+		return false, errors.New("annotation " + snapshotAnnotation + " could not be restored: " + err.Error())
+	}
+	return true, nil
+}
+
+// markRestored replaces the snapshot of the revision, once restored, with the record that it was restored
+func markRestored(obj metav1.Object, revision string) {
+	clearSnapshot(obj)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[restoredAnnotation] = revision
+	obj.SetAnnotations(annotations)
+}
+
+// snapshotRestored reports whether the snapshot of the revision was already restored
+func snapshotRestored(obj metav1.Object, revision string) bool {
+	restored, ok := obj.GetAnnotations()[restoredAnnotation]
+	return ok && restored == revision
+}
+
+// clearSnapshot removes the snapshot once the rollout no longer needs it
+func clearSnapshot(obj metav1.Object) {
+	annotations := obj.GetAnnotations()
+	delete(annotations, snapshotAnnotation)
+	delete(annotations, restoredAnnotation)
+	obj.SetAnnotations(annotations)
+}
+
+func readSnapshot(obj metav1.Object) (*specSnapshot, error) {
+	encoded, ok := obj.GetAnnotations()[snapshotAnnotation]
+	if !ok || encoded == "" {
+		return nil, nil
+	}
+	snapshot := &specSnapshot{}
+	if err := json.Unmarshal([]byte(encoded), snapshot); err != nil {
+		return nil, errors.New("annotation " + snapshotAnnotation + " could not be parsed: " + err.Error())
+	}
+	return snapshot, nil
+}
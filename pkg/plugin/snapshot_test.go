@@ -0,0 +1,140 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetWeightRestoresSnapshotOnAbort(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+
+	inputResolver := defaultResolver()
+	inputResolver.Spec.Subsets["canary"] = consulv1aplha1.ServiceResolverSubset{Filter: "Service.Meta.version == none", OnlyPassing: true}
+	inputResolver.Spec.DefaultSubset = "stable"
+	inputSplitter := defaultSplitter()
+	inputSplitter.Spec.Splits[0].RequestHeaders = &consulv1aplha1.HTTPHeaderModifiers{Set: map[string]string{"x-version": "stable"}}
+	originalResolverSpec := *inputResolver.Spec.DeepCopy()
+	originalSplitterSpec := *inputSplitter.Spec.DeepCopy()
+
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(inputResolver, inputSplitter).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	namespacedName := types.NamespacedName{Name: "test-service", Namespace: "default"}
+
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 40)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "3"}
+	rpcErr := p.SetWeight(rollout, 40, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	actualResolver := &consulv1aplha1.ServiceResolver{}
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualResolver, &client.GetOptions{}))
+	require.Equal(t, `Service.Meta.version == "2"`, actualResolver.Spec.Subsets["canary"].Filter)
+	require.Contains(t, actualResolver.Annotations, snapshotAnnotation)
+
+	// A later step of the same revision keeps the original snapshot
+	rollout = newTestRollout(pluginJson(), corev1.ConditionFalse, 80)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "3"}
+	rpcErr = p.SetWeight(rollout, 80, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	rollout = newTestRollout(pluginJson(), corev1.ConditionFalse, 0)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "3"}
+	rollout.Status.Abort = true
+	rollout.Status.AbortedAt = &metav1.Time{Time: time.Now()}
+	rpcErr = p.SetWeight(rollout, 0, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	actualResolver = &consulv1aplha1.ServiceResolver{}
+	actualSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualResolver, &client.GetOptions{}))
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+	require.Equal(t, originalResolverSpec, actualResolver.Spec)
+	require.Equal(t, originalSplitterSpec, actualSplitter.Spec)
+	require.NotContains(t, actualResolver.Annotations, snapshotAnnotation)
+	require.NotContains(t, actualSplitter.Annotations, snapshotAnnotation)
+
+	// Later calls for the aborted revision keep the restored specs
+	rpcErr = p.SetWeight(rollout, 0, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualResolver, &client.GetOptions{}))
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+	require.Equal(t, originalResolverSpec, actualResolver.Spec)
+	require.Equal(t, originalSplitterSpec, actualSplitter.Spec)
+	require.NotContains(t, actualResolver.Annotations, snapshotAnnotation)
+}
+
+func TestSetWeightRestoresIdleCanaryOnAbort(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 20)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "3"}
+	rpcErr := p.SetWeight(rollout, 20, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	rollout = newTestRollout(pluginJson(), corev1.ConditionFalse, 0)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "3"}
+	rollout.Status.Abort = true
+	rpcErr = p.SetWeight(rollout, 0, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	// The empty filter of the snapshot would match every instance, the canary subset is made idle instead
+	actualResolver := &consulv1aplha1.ServiceResolver{}
+	require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualResolver, &client.GetOptions{}))
+	require.Equal(t, idleCanaryFilter, actualResolver.Spec.Subsets["canary"].Filter)
+	require.Equal(t, defaultResolver().Spec.Subsets["stable"], actualResolver.Spec.Subsets["stable"])
+	require.NotContains(t, actualResolver.Annotations, snapshotAnnotation)
+}
+
+func TestSetWeightClearsSnapshotOnCompletion(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	inputResolver := defaultResolver()
+	require.NoError(t, recordSnapshot(inputResolver, "3", inputResolver.Spec))
+	inputSplitter := defaultSplitter()
+	require.NoError(t, recordSnapshot(inputSplitter, "3", inputSplitter.Spec))
+
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(inputResolver, inputSplitter).Build()
+	p := &RpcPlugin{
+		K8SClient: k8sClient,
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+	rollout := newTestRollout(pluginJson(), corev1.ConditionTrue, 0)
+	rollout.Annotations = map[string]string{"rollout.argoproj.io/revision": "3"}
+	rpcErr := p.SetWeight(rollout, 0, []v1alpha1.WeightDestination{})
+	require.Empty(t, rpcErr.ErrorString)
+
+	namespacedName := types.NamespacedName{Name: "test-service", Namespace: "default"}
+	actualResolver := &consulv1aplha1.ServiceResolver{}
+	actualSplitter := &consulv1aplha1.ServiceSplitter{}
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualResolver, &client.GetOptions{}))
+	require.NoError(t, k8sClient.Get(context.TODO(), namespacedName, actualSplitter, &client.GetOptions{}))
+	require.NotContains(t, actualResolver.Annotations, snapshotAnnotation)
+	require.NotContains(t, actualSplitter.Annotations, snapshotAnnotation)
+}
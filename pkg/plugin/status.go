@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RoutingStatus is the live Consul routing of a rollout
+type RoutingStatus struct {
+	Namespace string         `json:"namespace"`
+	Rollout   string         `json:"rollout"`
+	Service   string         `json:"service"`
+	LockOwner string         `json:"lockOwner,omitempty"`
+	Subsets   []SubsetStatus `json:"subsets"`
+	// Splitter and Resolver are the sync status of the config entries
+	Splitter ConfigEntryStatus `json:"splitter"`
+	Resolver ConfigEntryStatus `json:"resolver"`
+}
+
+// SubsetStatus is the weight and filter of a subset of the rollout service
+type SubsetStatus struct {
+	Name   string  `json:"name"`
+	Role   string  `json:"role"`
+	Weight float32 `json:"weight"`
+	Filter string  `json:"filter"`
+}
+
+// ConfigEntryStatus is the sync status of a Consul config entry
+type ConfigEntryStatus struct {
+	Kind       string       `json:"kind"`
+	Name       string       `json:"name"`
+	Synced     string       `json:"synced"`
+	Reason     string       `json:"reason,omitempty"`
+	Message    string       `json:"message,omitempty"`
+	LastSynced *metav1.Time `json:"lastSynced,omitempty"`
+}
+
+// RoutingStatus reads the plugin configuration of the rollout and returns the live state of its service splitter and
+// service resolver
+func (r *RpcPlugin) RoutingStatus(ctx context.Context, namespace, name string) (*RoutingStatus, error) {
+	rollout := &v1alpha1.Rollout{}
+	if err := r.rolloutReader().Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, rollout, &client.GetOptions{}); err != nil {
+		return nil, err
+	}
+	consulConfig, err := getPluginConfig(rollout, r.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	key := types.NamespacedName{Name: consulConfig.ServiceName, Namespace: namespace}
+	serviceSplitter := &consulv1aplha1.ServiceSplitter{}
+	if err := r.K8SClient.Get(ctx, key, serviceSplitter, &client.GetOptions{}); err != nil {
+		return nil, err
+	}
+	serviceResolver := &consulv1aplha1.ServiceResolver{}
+	if err := r.K8SClient.Get(ctx, key, serviceResolver, &client.GetOptions{}); err != nil {
+		return nil, err
+	}
+
+	status := &RoutingStatus{
+		Namespace: namespace,
+		Rollout:   name,
+		Service:   consulConfig.ServiceName,
+		Splitter:  configEntryStatus("ServiceSplitter", serviceSplitter.GetName(), serviceSplitter.Status),
+		Resolver:  configEntryStatus("ServiceResolver", serviceResolver.GetName(), serviceResolver.Status),
+	}
+	owner, err := readLock(serviceSplitter)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil {
+		status.LockOwner = owner.Rollout
+	}
+	for _, subset := range []struct{ name, role string }{
+		{consulConfig.CanarySubsetName, "canary"},
+		{consulConfig.StableSubsetName, "stable"},
+	} {
+		weight, _ := splitWeight(serviceSplitter, subset.name)
+		status.Subsets = append(status.Subsets, SubsetStatus{
+			Name:   subset.name,
+			Role:   subset.role,
+			Weight: weight,
+			Filter: serviceResolver.Spec.Subsets[subset.name].Filter,
+		})
+	}
+	return status, nil
+}
+
+func configEntryStatus(kind, name string, status consulv1aplha1.Status) ConfigEntryStatus {
+	entry := ConfigEntryStatus{Kind: kind, Name: name, Synced: "Unknown", LastSynced: status.LastSyncedTime}
+	for _, condition := range status.Conditions {
+		if condition.Type == consulv1aplha1.ConditionSynced {
+			entry.Synced = string(condition.Status)
+			entry.Reason = condition.Reason
+			entry.Message = condition.Message
+		}
+	}
+	return entry
+}
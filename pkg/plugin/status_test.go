@@ -0,0 +1,53 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRoutingStatus(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	require.NoError(t, v1alpha1.AddToScheme(s))
+	splitter := defaultSplitter()
+	splitter.Spec.Splits[0].Weight = 70
+	splitter.Spec.Splits[1].Weight = 30
+	splitter.Annotations = map[string]string{lockAnnotation: `{"rollout":"rollout","uid":"rollout-uid"}`}
+	resolver := defaultResolver()
+	resolver.Spec.Subsets["canary"] = consulv1aplha1.ServiceResolverSubset{Filter: "Service.Meta.version == 2"}
+	resolver.Status.Conditions[0].Status = corev1.ConditionFalse
+	resolver.Status.Conditions[0].Reason = "ConsulAgentError"
+	resolver.Status.Conditions[0].Message = "connection refused"
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 30)
+	p := &RpcPlugin{
+		K8SClient: fake.NewClientBuilder().WithScheme(s).WithObjects(splitter, resolver, rollout).Build(),
+		LogCtx:    logrus.NewEntry(logrus.New()),
+	}
+
+	status, err := p.RoutingStatus(context.TODO(), "default", "rollout")
+	require.NoError(t, err)
+	require.Equal(t, "test-service", status.Service)
+	require.Equal(t, "rollout", status.LockOwner)
+	require.Equal(t, []SubsetStatus{
+		{Name: "canary", Role: "canary", Weight: 30, Filter: "Service.Meta.version == 2"},
+		{Name: "stable", Role: "stable", Weight: 70, Filter: "Service.Meta.version == 1"},
+	}, status.Subsets)
+	require.Equal(t, "True", status.Splitter.Synced)
+	require.Equal(t, "False", status.Resolver.Synced)
+	require.Equal(t, "ConsulAgentError", status.Resolver.Reason)
+	require.Equal(t, "connection refused", status.Resolver.Message)
+
+	_, err = p.RoutingStatus(context.TODO(), "default", "missing")
+	require.Error(t, err)
+}
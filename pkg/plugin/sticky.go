@@ -0,0 +1,176 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+)
+
+const (
+	hashPolicyFieldCookie = "cookie"
+	hashPolicyFieldHeader = "header"
+
+	loadBalancerPolicyRingHash = "ring_hash"
+	loadBalancerPolicyMaglev   = "maglev"
+)
+
+// loadBalancerCreatedAnnotation marks a service resolver whose load balancer was created by the plugin for sticky
+// sessions, so that only that load balancer is removed afterwards and not one written by hand
+const loadBalancerCreatedAnnotation = "argo-rollouts.argoproj.io/consul-load-balancer-created"
+
+// StickySession configures sticky canary assignment. Exactly one of CookieName or HeaderName must be set.
+// Responses from the canary subset carry the cookie or header, and requests presenting it are routed to the canary
+// subset by a plugin-managed ServiceRouter route for the remainder of the current step.
+type StickySession struct {
+	CookieName string `json:"cookieName,omitempty" protobuf:"bytes,1,opt,name=cookieName"`
+	HeaderName string `json:"headerName,omitempty" protobuf:"bytes,2,opt,name=headerName"`
+}
+
+func validateStickySession(s *StickySession) error {
+	if s == nil {
+		return nil
+	}
+	if (s.CookieName == "") == (s.HeaderName == "") {
+		return errors.New("invalid consul traffic routing configuration. exactly one of stickySession.cookieName and stickySession.headerName must be set")
+	}
+	return nil
+}
+
+// stickyAssignmentValue identifies the canary for the current step. It changes with every step and every new canary
+// so that clients are only held on the canary for the duration of a single step.
+func stickyAssignmentValue(rollout *v1alpha1.Rollout) string {
+	var step int32
+	if rollout.Status.CurrentStepIndex != nil {
+		step = *rollout.Status.CurrentStepIndex
+	}
+	return fmt.Sprintf("%s-%d", rollout.Status.CurrentPodHash, step)
+}
+
+// stickyRoute returns the ServiceRouter route sending clients already assigned to the canary to the canary subset
+func stickyRoute(s *StickySession, canarySubsetName, assignment string) consulv1aplha1.ServiceRoute {
+	header := consulv1aplha1.ServiceRouteHTTPMatchHeader{}
+	if s.CookieName != "" {
+		// Envoy matches the regex against the whole header value, so allow for other cookies either side
+		header.Name = "cookie"
+		header.Regex = fmt.Sprintf(`(.*;\s*)?%s=%s(;.*)?`, regexp.QuoteMeta(s.CookieName), regexp.QuoteMeta(assignment))
+	} else {
+		header.Name = s.HeaderName
+		header.Exact = assignment
+	}
+	return consulv1aplha1.ServiceRoute{
+		Match: &consulv1aplha1.ServiceRouteMatch{
+			HTTP: &consulv1aplha1.ServiceRouteHTTPMatch{
+				Header: []consulv1aplha1.ServiceRouteHTTPMatchHeader{header},
+			},
+		},
+		Destination: &consulv1aplha1.ServiceRouteDestination{
+			ServiceSubset: canarySubsetName,
+		},
+	}
+}
+
+// stickyResponseHeaders returns the response header modifiers that assign a client to the canary
+func stickyResponseHeaders(s *StickySession, assignment string) *consulv1aplha1.HTTPHeaderModifiers {
+	if s.CookieName != "" {
+		return &consulv1aplha1.HTTPHeaderModifiers{
+			Add: map[string]string{"Set-Cookie": fmt.Sprintf("%s=%s; Path=/", s.CookieName, assignment)},
+		}
+	}
+	return &consulv1aplha1.HTTPHeaderModifiers{
+		Set: map[string]string{s.HeaderName: assignment},
+	}
+}
+
+// setStickyResponseHeader adds the sticky assignment to the response headers of the split when active, and removes it
+// otherwise. The other header modifiers of the split are kept as they are.
+func setStickyResponseHeader(split *consulv1aplha1.ServiceSplit, s *StickySession, active bool, assignment string) {
+	if active {
+		split.ResponseHeaders = mergeHeaderModifiers(split.ResponseHeaders, stickyResponseHeaders(s, assignment))
+		return
+	}
+	if split.ResponseHeaders == nil {
+		return
+	}
+	headers := split.ResponseHeaders.DeepCopy()
+	if s.CookieName != "" {
+		if strings.HasPrefix(headers.Add["Set-Cookie"], s.CookieName+"=") {
+			delete(headers.Add, "Set-Cookie")
+		}
+	} else {
+		delete(headers.Set, s.HeaderName)
+	}
+	if len(headers.Add) == 0 {
+		headers.Add = nil
+	}
+	if len(headers.Set) == 0 {
+		headers.Set = nil
+	}
+	if headers.Add == nil && headers.Set == nil && len(headers.Remove) == 0 {
+		headers = nil
+	}
+	split.ResponseHeaders = headers
+}
+
+// stickyHashPolicy returns the load balancer hash policy keyed on the sticky cookie or header
+func stickyHashPolicy(s *StickySession) consulv1aplha1.HashPolicy {
+	if s.CookieName != "" {
+		return consulv1aplha1.HashPolicy{Field: hashPolicyFieldCookie, FieldValue: s.CookieName}
+	}
+	return consulv1aplha1.HashPolicy{Field: hashPolicyFieldHeader, FieldValue: s.HeaderName}
+}
+
+// updateResolverHashPolicy adds the sticky hash policy to the resolver load balancer when active, and removes it
+// otherwise. A load balancer created by the plugin, as recorded by loadBalancerCreatedAnnotation, is removed again once
+// it no longer holds any hash policies.
+func updateResolverHashPolicy(s *StickySession, active bool, sr *consulv1aplha1.ServiceResolver) (*consulv1aplha1.ServiceResolver, error) {
+	policy := stickyHashPolicy(s)
+	lb := sr.Spec.LoadBalancer
+	if active {
+		if lb == nil {
+			lb = &consulv1aplha1.LoadBalancer{Policy: loadBalancerPolicyRingHash}
+			if sr.Annotations == nil {
+				sr.Annotations = map[string]string{}
+			}
+			sr.Annotations[loadBalancerCreatedAnnotation] = "true"
+		}
+		if lb.Policy != loadBalancerPolicyRingHash && lb.Policy != loadBalancerPolicyMaglev {
+			return nil, fmt.Errorf("sticky sessions require a %s or %s load balancer policy, service resolver has %q", loadBalancerPolicyRingHash, loadBalancerPolicyMaglev, lb.Policy)
+		}
+		for _, hp := range lb.HashPolicies {
+			if reflect.DeepEqual(hp, policy) {
+				sr.Spec.LoadBalancer = lb
+				return sr, nil
+			}
+		}
+		lb.HashPolicies = append([]consulv1aplha1.HashPolicy{policy}, lb.HashPolicies...)
+		sr.Spec.LoadBalancer = lb
+		return sr, nil
+	}
+
+	if lb == nil {
+		return sr, nil
+	}
+	var remaining []consulv1aplha1.HashPolicy
+	for _, hp := range lb.HashPolicies {
+		if !reflect.DeepEqual(hp, policy) {
+			remaining = append(remaining, hp)
+		}
+	}
+	lb.HashPolicies = remaining
+	if sr.Annotations[loadBalancerCreatedAnnotation] == "true" {
+		if reflect.DeepEqual(*lb, consulv1aplha1.LoadBalancer{Policy: loadBalancerPolicyRingHash}) {
+			lb = nil
+		}
+		delete(sr.Annotations, loadBalancerCreatedAnnotation)
+	}
+	sr.Spec.LoadBalancer = lb
+	return sr, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// decodeConfig migrates the plugin configuration of a rollout to ConfigAPIVersion and decodes it. Unknown fields are
+// rejected, naming their path and the closest known field, so that a misspelled option is not silently ignored.
+func decodeConfig(data []byte, cfg *ConsulTrafficRouting) error {
+	data, err := migratedConfigData(data)
+	if err != nil {
+		return err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if unknown := unknownFields(raw, reflect.TypeOf(cfg).Elem(), ""); len(unknown) > 0 {
+		return errors.New("invalid consul traffic routing configuration. " + strings.Join(unknown, ", "))
+	}
+	return json.Unmarshal(data, cfg)
+}
+
+// unknownFields returns a message for every key of value that has no field in t, which value is decoded into
+func unknownFields(value interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+	var messages []string
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := lookupJSONField(fields, key)
+			if !ok {
+				messages = append(messages, unknownFieldMessage(joinPath(path, key), key, fields))
+				continue
+			}
+			messages = append(messages, unknownFields(object[key], field.Type, joinPath(path, field.name))...)
+		}
+	case reflect.Slice:
+		items, _ := value.([]interface{})
+		for i, item := range items {
+			messages = append(messages, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Map:
+		object, _ := value.(map[string]interface{})
+		for key, item := range object {
+			messages = append(messages, unknownFields(item, t.Elem(), joinPath(path, key))...)
+		}
+		sort.Strings(messages)
+	}
+	return messages
+}
+
+// jsonField is a struct field with its JSON name
+type jsonField struct {
+	reflect.StructField
+	name      string
+	omitEmpty bool
+}
+
+// jsonFields returns the fields of t by JSON name, in declaration order
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{StructField: field, name: name, omitEmpty: strings.Contains(options, "omitempty")})
+	}
+	return fields
+}
+
+// lookupJSONField finds the field a key is decoded into. Like encoding/json, an exact match is preferred over a case
+// insensitive one.
+func lookupJSONField(fields []jsonField, key string) (jsonField, bool) {
+	for _, field := range fields {
+		if field.name == key {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, key) {
+			return field, true
+		}
+	}
+	return jsonField{}, false
+}
+
+func unknownFieldMessage(path, key string, fields []jsonField) string {
+	message := fmt.Sprintf("unknown field %q", path)
+	best, bestDistance := "", len(key)/2+1
+	for _, field := range fields {
+		distance := levenshtein(strings.ToLower(key), strings.ToLower(field.name))
+		if strings.HasPrefix(strings.ToLower(field.name), strings.ToLower(key)) {
+			distance = 0
+		}
+		if distance < bestDistance {
+			best, bestDistance = field.name, distance
+		}
+	}
+	if best != "" {
+		message += fmt.Sprintf(", did you mean %q?", best)
+	}
+	return message
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
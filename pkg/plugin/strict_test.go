@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	testCases := map[string]struct {
+		config        string
+		expectedError string
+	}{
+		"known fields": {
+			config: `{"serviceName":"test-service","canarySubsetName":"canary","stableSubsetName":"stable","stickySession":{"cookieName":"canary"},"canaryRouteOptions":{"requestTimeout":"2s"}}`,
+		},
+		"field names match case insensitively": {
+			config: `{"ServiceName":"test-service"}`,
+		},
+		"misspelled field": {
+			config:        `{"serviceName":"test-service","canarySubset":"canary","stableSubsetName":"stable"}`,
+			expectedError: `invalid consul traffic routing configuration. unknown field "canarySubset", did you mean "canarySubsetName"?`,
+		},
+		"nested unknown fields": {
+			config:        `{"stickySession":{"cookie":"canary"},"canaryRoutes":[{"pathPrefix":"/api"},{"pathPrefx":"/v2"}],"canarySplitHeaders":{"requestHeaders":{"sett":{"x-canary":"true"}}}}`,
+			expectedError: `invalid consul traffic routing configuration. unknown field "canaryRoutes[1].pathPrefx", did you mean "pathPrefix"?, unknown field "canarySplitHeaders.requestHeaders.sett", did you mean "set"?, unknown field "stickySession.cookie", did you mean "cookieName"?`,
+		},
+		"unknown field without a close match": {
+			config:        `{"timeoutSeconds":5}`,
+			expectedError: `invalid consul traffic routing configuration. unknown field "timeoutSeconds"`,
+		},
+		"invalid JSON": {
+			config:        `{"serviceName":`,
+			expectedError: "unexpected end of JSON input",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := decodeConfig([]byte(testCase.config), &ConsulTrafficRouting{})
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, testCase.expectedError)
+			}
+		})
+	}
+}
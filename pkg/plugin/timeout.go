@@ -0,0 +1,172 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultRPCTimeout bounds every RPC call of the plugin, so that a hung API server does not block the controller
+const defaultRPCTimeout = 30 * time.Second
+
+// rpcTimeout returns the configured deadline of RPC calls, or the default if none is configured
+func (s *PluginSettings) rpcTimeout() time.Duration {
+	if s == nil || s.RPCTimeout.Duration == 0 {
+		return defaultRPCTimeout
+	}
+	return s.RPCTimeout.Duration
+}
+
+// rpcContext returns the context of an RPC call, which expires after the configured deadline
+func (r *RpcPlugin) rpcContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.Settings.rpcTimeout())
+}
+
+// retryablePrefix starts the error of an RPC call that failed because its deadline expired. Such a call can be retried
+// as is, the rollouts controller calls the plugin again on its next reconciliation.
+const retryablePrefix = "retryable: "
+
+// isRetryable reports whether the error of an RPC call is marked as retryable
+func isRetryable(rpcErr pluginTypes.RpcError) bool {
+	return strings.HasPrefix(rpcErr.ErrorString, retryablePrefix)
+}
+
+// retryableOnTimeout marks the error of an RPC call whose deadline expired as retryable
+func retryableOnTimeout(ctx context.Context, rpcErr *pluginTypes.RpcError) {
+	if rpcErr.HasError() && errors.Is(ctx.Err(), context.DeadlineExceeded) && !isRetryable(*rpcErr) {
+		rpcErr.ErrorString = retryablePrefix + rpcErr.ErrorString
+	}
+}
+
+// timeoutError is the error of a Kubernetes call that failed because the deadline of the RPC call expired
+type timeoutError struct {
+	call string
+	kind string
+	name string
+	err  error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s %s %s timed out: %s", e.call, e.kind, e.name, e.err)
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
+// deadlineClient logs the Kubernetes calls that fail because the deadline of the RPC call expired, and returns them
+// as a timeoutError naming the call. Scheme, RESTMapper, GroupVersionKindFor and IsObjectNamespaced are not bound to
+// the deadline, so they are passed through.
+type deadlineClient struct {
+	client.Client
+	plugin *RpcPlugin
+}
+
+func (c deadlineClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.plugin.checkDeadline(ctx, "get", obj, key.String(), c.Client.Get(ctx, key, obj, opts...))
+}
+
+func (c deadlineClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	return c.plugin.checkDeadline(ctx, "list", list, listOpts.Namespace, c.Client.List(ctx, list, opts...))
+}
+
+func (c deadlineClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.plugin.checkDeadline(ctx, "create", obj, client.ObjectKeyFromObject(obj).String(), c.Client.Create(ctx, obj, opts...))
+}
+
+func (c deadlineClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.plugin.checkDeadline(ctx, "update", obj, client.ObjectKeyFromObject(obj).String(), c.Client.Update(ctx, obj, opts...))
+}
+
+func (c deadlineClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.plugin.checkDeadline(ctx, "patch", obj, client.ObjectKeyFromObject(obj).String(), c.Client.Patch(ctx, obj, patch, opts...))
+}
+
+func (c deadlineClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.plugin.checkDeadline(ctx, "delete", obj, client.ObjectKeyFromObject(obj).String(), c.Client.Delete(ctx, obj, opts...))
+}
+
+func (c deadlineClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	deleteOpts := (&client.DeleteAllOfOptions{}).ApplyOptions(opts)
+	return c.plugin.checkDeadline(ctx, "delete all of", obj, deleteOpts.Namespace, c.Client.DeleteAllOf(ctx, obj, opts...))
+}
+
+func (c deadlineClient) Status() client.SubResourceWriter {
+	return deadlineSubResourceWriter{SubResourceWriter: c.Client.Status(), subResource: "status", deadline: c}
+}
+
+func (c deadlineClient) SubResource(subResource string) client.SubResourceClient {
+	subResourceClient := c.Client.SubResource(subResource)
+	return deadlineSubResourceClient{
+		deadlineSubResourceWriter: deadlineSubResourceWriter{SubResourceWriter: subResourceClient, subResource: subResource, deadline: c},
+		reader:                    subResourceClient,
+	}
+}
+
+// checkDeadline logs a Kubernetes call that failed because the deadline of the RPC call expired, and returns it as a
+// timeoutError
+func (r *RpcPlugin) checkDeadline(ctx context.Context, call string, obj runtime.Object, name string, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	kind := reflect.TypeOf(obj).Elem().Name()
+	r.LogCtx.WithFields(logrus.Fields{"call": call, "kind": kind, "name": name, "timeout": r.Settings.rpcTimeout().String()}).Error("Kubernetes API call timed out")
+	return &timeoutError{call: call, kind: kind, name: name, err: err}
+}
+
+// deadlineReader is the deadlineClient of a reader, such as the reader of Rollouts or the API server reader bypassing
+// the cache
+type deadlineReader struct {
+	client.Reader
+	plugin *RpcPlugin
+}
+
+func (c deadlineReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.plugin.checkDeadline(ctx, "get", obj, key.String(), c.Reader.Get(ctx, key, obj, opts...))
+}
+
+func (c deadlineReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	return c.plugin.checkDeadline(ctx, "list", list, listOpts.Namespace, c.Reader.List(ctx, list, opts...))
+}
+
+// deadlineSubResourceWriter is the deadlineClient of the writes of a sub-resource, such as the status
+type deadlineSubResourceWriter struct {
+	client.SubResourceWriter
+	subResource string
+	deadline    deadlineClient
+}
+
+func (w deadlineSubResourceWriter) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	return w.deadline.plugin.checkDeadline(ctx, "create "+w.subResource, obj, client.ObjectKeyFromObject(obj).String(), w.SubResourceWriter.Create(ctx, obj, subResource, opts...))
+}
+
+func (w deadlineSubResourceWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	return w.deadline.plugin.checkDeadline(ctx, "update "+w.subResource, obj, client.ObjectKeyFromObject(obj).String(), w.SubResourceWriter.Update(ctx, obj, opts...))
+}
+
+func (w deadlineSubResourceWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	return w.deadline.plugin.checkDeadline(ctx, "patch "+w.subResource, obj, client.ObjectKeyFromObject(obj).String(), w.SubResourceWriter.Patch(ctx, obj, patch, opts...))
+}
+
+// deadlineSubResourceClient is the deadlineClient of a sub-resource
+type deadlineSubResourceClient struct {
+	deadlineSubResourceWriter
+	reader client.SubResourceReader
+}
+
+func (c deadlineSubResourceClient) Get(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	return c.deadline.plugin.checkDeadline(ctx, "get "+c.subResource, obj, client.ObjectKeyFromObject(obj).String(), c.reader.Get(ctx, obj, subResource, opts...))
+}
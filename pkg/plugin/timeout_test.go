@@ -0,0 +1,266 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// hangingClient blocks updates until their context is done, like an API server that does not respond
+type hangingClient struct {
+	client.Client
+}
+
+func (c hangingClient) Update(ctx context.Context, _ client.Object, _ ...client.UpdateOption) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSetWeightTimeout(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	logger, hook := logtest.NewNullLogger()
+	p := &RpcPlugin{
+		K8SClient: hangingClient{Client: fake.NewClientBuilder().WithScheme(s).WithObjects(defaultResolver(), defaultSplitter()).Build()},
+		IsTest:    true,
+		LogCtx:    logrus.NewEntry(logger),
+		Settings:  &PluginSettings{RPCTimeout: metav1.Duration{Duration: 50 * time.Millisecond}},
+	}
+
+	rpcErr := p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 20), 20, []v1alpha1.WeightDestination{})
+	require.Equal(t, "retryable: update ServiceSplitter default/test-service timed out: context deadline exceeded", rpcErr.ErrorString)
+	require.True(t, isRetryable(rpcErr))
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, logrus.ErrorLevel, entry.Level)
+	require.Equal(t, "Kubernetes API call timed out", entry.Message)
+	require.Equal(t, "update", entry.Data["call"])
+	require.Equal(t, "ServiceSplitter", entry.Data["kind"])
+	require.Equal(t, "test-service", entry.Data["service"])
+	require.Equal(t, "50ms", entry.Data["timeout"])
+}
+
+// hangingReader blocks reads until their context is done
+type hangingReader struct {
+	client.Reader
+}
+
+func (r hangingReader) Get(ctx context.Context, _ client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSetWeightReaderTimeout(t *testing.T) {
+	testCases := map[string]struct {
+		inputSplitter *consulv1aplha1.ServiceSplitter
+		inputResolver *consulv1aplha1.ServiceResolver
+		rolloutReader client.Reader
+		apiReader     client.Reader
+		expectedError string
+		expectedKind  string
+	}{
+		"owner of the lock": {
+			inputSplitter: func() *consulv1aplha1.ServiceSplitter {
+				splitter := defaultSplitter()
+				splitter.Annotations = map[string]string{lockAnnotation: `{"rollout":"other","uid":"other-uid"}`}
+				return splitter
+			}(),
+			inputResolver: defaultResolver(),
+			rolloutReader: hangingReader{},
+			expectedError: "retryable: owner of the lock could not be read: get Rollout default/other timed out: context deadline exceeded",
+			expectedKind:  "Rollout",
+		},
+		"live state of an unsynced resolver": {
+			inputSplitter: defaultSplitter(),
+			inputResolver: func() *consulv1aplha1.ServiceResolver {
+				resolver := defaultResolver()
+				resolver.Status.Conditions[0].Status = corev1.ConditionFalse
+				return resolver
+			}(),
+			apiReader:     hangingReader{},
+			expectedError: "retryable: get ServiceResolver default/test-service timed out: context deadline exceeded",
+			expectedKind:  "ServiceResolver",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			logger, hook := logtest.NewNullLogger()
+			p := &RpcPlugin{
+				K8SClient:     fake.NewClientBuilder().WithScheme(s).WithObjects(testCase.inputResolver, testCase.inputSplitter).Build(),
+				RolloutReader: testCase.rolloutReader,
+				APIReader:     testCase.apiReader,
+				IsTest:        true,
+				LogCtx:        logrus.NewEntry(logger),
+				Settings:      &PluginSettings{RPCTimeout: metav1.Duration{Duration: 50 * time.Millisecond}},
+			}
+
+			rpcErr := p.SetWeight(newTestRollout(pluginJson(), corev1.ConditionFalse, 20), 20, []v1alpha1.WeightDestination{})
+			require.Equal(t, testCase.expectedError, rpcErr.ErrorString)
+			require.True(t, isRetryable(rpcErr))
+
+			entry := hook.LastEntry()
+			require.NotNil(t, entry)
+			require.Equal(t, "Kubernetes API call timed out", entry.Message)
+			require.Equal(t, "get", entry.Data["call"])
+			require.Equal(t, testCase.expectedKind, entry.Data["kind"])
+		})
+	}
+}
+
+func TestDeadlineClient(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, consulv1aplha1.AddToScheme(s))
+	expired := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(defaultSplitter()).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, _ client.WithWatch, _ client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+			return expired(ctx)
+		},
+		List: func(ctx context.Context, _ client.WithWatch, _ client.ObjectList, _ ...client.ListOption) error {
+			return expired(ctx)
+		},
+		Create: func(ctx context.Context, _ client.WithWatch, _ client.Object, _ ...client.CreateOption) error {
+			return expired(ctx)
+		},
+		Update: func(ctx context.Context, _ client.WithWatch, _ client.Object, _ ...client.UpdateOption) error {
+			return expired(ctx)
+		},
+		Patch: func(ctx context.Context, _ client.WithWatch, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+			return expired(ctx)
+		},
+		Delete: func(ctx context.Context, _ client.WithWatch, _ client.Object, _ ...client.DeleteOption) error {
+			return expired(ctx)
+		},
+		DeleteAllOf: func(ctx context.Context, _ client.WithWatch, _ client.Object, _ ...client.DeleteAllOfOption) error {
+			return expired(ctx)
+		},
+		SubResourceGet: func(ctx context.Context, _ client.Client, _ string, _ client.Object, _ client.Object, _ ...client.SubResourceGetOption) error {
+			return expired(ctx)
+		},
+		SubResourceUpdate: func(ctx context.Context, _ client.Client, _ string, _ client.Object, _ ...client.SubResourceUpdateOption) error {
+			return expired(ctx)
+		},
+		SubResourcePatch: func(ctx context.Context, _ client.Client, _ string, _ client.Object, _ client.Patch, _ ...client.SubResourcePatchOption) error {
+			return expired(ctx)
+		},
+	}).Build()
+	logger, hook := logtest.NewNullLogger()
+	c := deadlineClient{Client: k8sClient, plugin: &RpcPlugin{LogCtx: logrus.NewEntry(logger)}}
+	splitter := defaultSplitter()
+	patch := client.MergeFrom(defaultSplitter())
+
+	testCases := map[string]struct {
+		call          func(ctx context.Context) error
+		expectedError string
+	}{
+		"get": {
+			call:          func(ctx context.Context) error { return c.Get(ctx, client.ObjectKeyFromObject(splitter), splitter) },
+			expectedError: "get ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+		"list": {
+			call: func(ctx context.Context) error {
+				return c.List(ctx, &consulv1aplha1.ServiceSplitterList{}, client.InNamespace("default"))
+			},
+			expectedError: "list ServiceSplitterList default timed out: context deadline exceeded",
+		},
+		"create": {
+			call:          func(ctx context.Context) error { return c.Create(ctx, splitter) },
+			expectedError: "create ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+		"update": {
+			call:          func(ctx context.Context) error { return c.Update(ctx, splitter) },
+			expectedError: "update ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+		"patch": {
+			call:          func(ctx context.Context) error { return c.Patch(ctx, splitter, patch) },
+			expectedError: "patch ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+		"delete": {
+			call:          func(ctx context.Context) error { return c.Delete(ctx, splitter) },
+			expectedError: "delete ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+		"delete all of": {
+			call:          func(ctx context.Context) error { return c.DeleteAllOf(ctx, splitter, client.InNamespace("default")) },
+			expectedError: "delete all of ServiceSplitter default timed out: context deadline exceeded",
+		},
+		"update status": {
+			call:          func(ctx context.Context) error { return c.Status().Update(ctx, splitter) },
+			expectedError: "update status ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+		"patch status": {
+			call:          func(ctx context.Context) error { return c.Status().Patch(ctx, splitter, patch) },
+			expectedError: "patch status ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+		"get sub-resource": {
+			call: func(ctx context.Context) error {
+				return c.SubResource("status").Get(ctx, splitter, &consulv1aplha1.ServiceSplitter{})
+			},
+			expectedError: "get status ServiceSplitter default/test-service timed out: context deadline exceeded",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			hook.Reset()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			err := testCase.call(ctx)
+			require.EqualError(t, err, testCase.expectedError)
+			var timeoutErr *timeoutError
+			require.ErrorAs(t, err, &timeoutErr)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.Len(t, hook.AllEntries(), 1)
+		})
+	}
+
+	c.Client = fake.NewClientBuilder().WithScheme(s).WithObjects(defaultSplitter()).Build()
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(splitter), splitter), "calls within the deadline are not changed")
+}
+
+func TestRetryableOnTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	rpcErr := pluginTypes.RpcError{ErrorString: "update failed"}
+	retryableOnTimeout(ctx, &rpcErr)
+	require.Equal(t, "retryable: update failed", rpcErr.ErrorString)
+	retryableOnTimeout(ctx, &rpcErr)
+	require.Equal(t, "retryable: update failed", rpcErr.ErrorString, "errors are marked once")
+
+	rpcErr = pluginTypes.RpcError{ErrorString: "update failed"}
+	retryableOnTimeout(context.Background(), &rpcErr)
+	require.False(t, isRetryable(rpcErr))
+
+	rpcErr = pluginTypes.RpcError{}
+	retryableOnTimeout(ctx, &rpcErr)
+	require.False(t, rpcErr.HasError())
+}
+
+func TestRPCTimeout(t *testing.T) {
+	require.Equal(t, defaultRPCTimeout, (*PluginSettings)(nil).rpcTimeout())
+	require.Equal(t, time.Second, (&PluginSettings{RPCTimeout: metav1.Duration{Duration: time.Second}}).rpcTimeout())
+
+	_, err := parseSettings([]byte(`rpcTimeout: -1s`))
+	require.ErrorContains(t, err, "rpcTimeout must not be negative")
+}
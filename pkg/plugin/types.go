@@ -9,3 +9,12 @@ const Type = "Consul"
 // ConfigKey used to identify the plugin in argo-rollouts configmap.
 // see https://argoproj.github.io/argo-rollouts/features/traffic-management/plugins/
 const ConfigKey = "hashicorp/consul"
+
+// LegacyConfigKey is the name the plugin was first registered under in the argo-rollouts configmap
+const LegacyConfigKey = "argoproj-labs/consul"
+
+// ConfigKeys are the names the plugin may be registered under. A rollout configures the plugin under the same name.
+var ConfigKeys = []string{ConfigKey, LegacyConfigKey}
+
+// ConfigAPIVersion is the current version of the plugin configuration of a rollout
+const ConfigAPIVersion = "v1"
@@ -0,0 +1,191 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Manifests are the objects checked by Validate
+type Manifests struct {
+	Rollouts  []*v1alpha1.Rollout
+	Resolvers []*consulv1aplha1.ServiceResolver
+	Splitters []*consulv1aplha1.ServiceSplitter
+	// Deployments are the workloads the rollouts may reference in spec.workloadRef
+	Deployments []*appsv1.Deployment
+}
+
+// ValidationError is a problem found in a manifest
+type ValidationError struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Message   string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s %s/%s: %s", e.Kind, e.Namespace, e.Name, e.Message)
+}
+
+// InitOffline loads the plugin settings from SettingsFile, for commands that do not connect to a cluster
+func (r *RpcPlugin) InitOffline() error {
+	if r.SettingsConfigMap != "" {
+		return errors.New("plugin settings cannot be read from a ConfigMap without connecting to a cluster")
+	}
+	settings, err := r.loadSettings(context.Background())
+	if err != nil {
+		return err
+	}
+	r.Settings = settings
+	return nil
+}
+
+// Validate runs the checks SetWeight makes on the rollouts using the plugin and on their service resolvers and service
+// splitters, without connecting to a cluster. Rollouts that do not use the plugin are ignored.
+func (r *RpcPlugin) Validate(manifests Manifests) []ValidationError {
+	var validationErrors []ValidationError
+	resolvers := map[string]*consulv1aplha1.ServiceResolver{}
+	for _, resolver := range manifests.Resolvers {
+		resolvers[manifestKey(resolver.GetNamespace(), resolver.GetName())] = resolver
+		for _, message := range validateResolverFilters(resolver) {
+			validationErrors = append(validationErrors, manifestError("ServiceResolver", resolver.GetNamespace(), resolver.GetName(), message))
+		}
+	}
+	splitters := map[string]*consulv1aplha1.ServiceSplitter{}
+	for _, splitter := range manifests.Splitters {
+		splitters[manifestKey(splitter.GetNamespace(), splitter.GetName())] = splitter
+	}
+	deployments := map[string]*appsv1.Deployment{}
+	for _, deployment := range manifests.Deployments {
+		deployments[manifestKey(deployment.GetNamespace(), deployment.GetName())] = deployment
+	}
+
+	for _, rollout := range manifests.Rollouts {
+		if !usesPlugin(rollout) {
+			continue
+		}
+		rolloutError := func(message string) {
+			validationErrors = append(validationErrors, manifestError("Rollout", rollout.GetNamespace(), rollout.GetName(), message))
+		}
+		consulConfig, err := getPluginConfig(rollout, r.Settings)
+		if err != nil {
+			rolloutError(err.Error())
+			continue
+		}
+		templateRollout, err := resolveWorkloadRef(rollout, func(name string) (*appsv1.Deployment, error) {
+			deployment, ok := deployments[manifestKey(rollout.GetNamespace(), name)]
+			if !ok {
+				return nil, fmt.Errorf("deployment %s referenced by spec.workloadRef was not found in the manifests", name)
+			}
+			return deployment, nil
+		})
+		if err != nil {
+			rolloutError(err.Error())
+		} else {
+			for _, subsetName := range []string{consulConfig.CanarySubsetName, consulConfig.StableSubsetName} {
+				if _, err := subsetFilter(consulConfig, templateRollout, subsetName); err != nil {
+					rolloutError(err.Error())
+				}
+			}
+		}
+
+		key := manifestKey(rollout.GetNamespace(), consulConfig.ServiceName)
+		resolver, ok := resolvers[key]
+		if !ok {
+			rolloutError(fmt.Sprintf("service resolver %s was not found in the manifests", consulConfig.ServiceName))
+		} else {
+			for _, message := range validateResolverSubsets(resolver, consulConfig) {
+				validationErrors = append(validationErrors, manifestError("ServiceResolver", resolver.GetNamespace(), resolver.GetName(), message))
+			}
+		}
+		splitter, ok := splitters[key]
+		if !ok {
+			rolloutError(fmt.Sprintf("service splitter %s was not found in the manifests", consulConfig.ServiceName))
+		} else {
+			for _, message := range validateSplits(splitter, consulConfig) {
+				validationErrors = append(validationErrors, manifestError("ServiceSplitter", splitter.GetNamespace(), splitter.GetName(), message))
+			}
+		}
+	}
+	return validationErrors
+}
+
+// validateResolverFilters checks the syntax of the filters of every subset of the resolver
+func validateResolverFilters(resolver *consulv1aplha1.ServiceResolver) []string {
+	subsetNames := make([]string, 0, len(resolver.Spec.Subsets))
+	for name := range resolver.Spec.Subsets {
+		subsetNames = append(subsetNames, name)
+	}
+	sort.Strings(subsetNames)
+	var messages []string
+	for _, name := range subsetNames {
+		filter := resolver.Spec.Subsets[name].Filter
+		if filter == "" {
+			continue
+		}
+		if err := validateFilter(filter); err != nil {
+			messages = append(messages, fmt.Sprintf("spec.subsets.%s.filter: %s", name, err))
+		}
+	}
+	return messages
+}
+
+// validateResolverSubsets checks that the resolver defines the subsets of the rollout
+func validateResolverSubsets(resolver *consulv1aplha1.ServiceResolver, cfg *ConsulTrafficRouting) []string {
+	var messages []string
+	for _, subsetName := range []string{cfg.CanarySubsetName, cfg.StableSubsetName} {
+		if _, ok := resolver.Spec.Subsets[subsetName]; !ok {
+			messages = append(messages, fmt.Sprintf("spec.subsets.%s was not found, it is required by the rollout configuration", subsetName))
+		}
+	}
+	return messages
+}
+
+// validateSplits checks that the splitter has one split for each subset of the rollout, with weights adding up to 100
+func validateSplits(splitter *consulv1aplha1.ServiceSplitter, cfg *ConsulTrafficRouting) []string {
+	splits := splitter.Spec.Splits
+	if len(splits) != 2 {
+		return []string{fmt.Sprintf("unexpected number of service splits. Expected 2, found %d", len(splits))}
+	}
+	var messages []string
+	var total float32
+	for i, split := range splits {
+		if split.ServiceSubset != cfg.CanarySubsetName && split.ServiceSubset != cfg.StableSubsetName {
+			messages = append(messages, fmt.Sprintf("spec.splits[%d].serviceSubset %q is neither the canary subset %s nor the stable subset %s", i, split.ServiceSubset, cfg.CanarySubsetName, cfg.StableSubsetName))
+		}
+		if split.Weight < 0 || split.Weight > 100 {
+			messages = append(messages, fmt.Sprintf("spec.splits[%d].weight %g must be between 0 and 100", i, split.Weight))
+		}
+		total += split.Weight
+	}
+	if splits[0].ServiceSubset == splits[1].ServiceSubset {
+		messages = append(messages, fmt.Sprintf("spec.splits has two splits for subset %s", splits[0].ServiceSubset))
+	}
+	if total != 100 {
+		messages = append(messages, fmt.Sprintf("the weights of spec.splits add up to %g, expected 100", total))
+	}
+	return messages
+}
+
+// manifestKey identifies an object of the manifests. Objects without a namespace are in the default namespace.
+func manifestKey(namespace, name string) string {
+	if namespace == "" {
+		namespace = "default"
+	}
+	return namespace + "/" + name
+}
+
+func manifestError(kind, namespace, name, message string) ValidationError {
+	if namespace == "" {
+		namespace = "default"
+	}
+	return ValidationError{Kind: kind, Namespace: namespace, Name: name, Message: message}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidate(t *testing.T) {
+	withoutAnnotation := func() *v1alpha1.Rollout {
+		rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 0)
+		rollout.Spec.Template.Annotations = nil
+		return rollout
+	}
+	testCases := map[string]struct {
+		manifests      Manifests
+		expectedErrors []string
+	}{
+		"valid manifests": {
+			manifests: Manifests{
+				Rollouts:  []*v1alpha1.Rollout{newTestRollout(pluginJson(), corev1.ConditionFalse, 0)},
+				Resolvers: []*consulv1aplha1.ServiceResolver{defaultResolver()},
+				Splitters: []*consulv1aplha1.ServiceSplitter{defaultSplitter()},
+			},
+		},
+		"rollout without the plugin is ignored": {
+			manifests: Manifests{
+				Rollouts: []*v1alpha1.Rollout{{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}},
+			},
+		},
+		"invalid plugin config": {
+			manifests: Manifests{
+				Rollouts: []*v1alpha1.Rollout{newTestRollout(invalidPlugin(), corev1.ConditionFalse, 0)},
+			},
+			expectedErrors: []string{
+				"Rollout default/rollout: invalid consul traffic routing configuration. stableSubsetName, canarySubsetName, and serviceName must be set",
+			},
+		},
+		"missing version annotation and config entries": {
+			manifests: Manifests{
+				Rollouts: []*v1alpha1.Rollout{withoutAnnotation()},
+			},
+			expectedErrors: []string{
+				"Rollout default/rollout: annotation consul.hashicorp.com/service-meta-version is missing or empty on the pod template of rollout default/rollout. It is required to select the canary version in the service resolver",
+				"Rollout default/rollout: annotation consul.hashicorp.com/service-meta-version is missing or empty on the pod template of rollout default/rollout. It is required to select the stable version in the service resolver",
+				"Rollout default/rollout: service resolver test-service was not found in the manifests",
+				"Rollout default/rollout: service splitter test-service was not found in the manifests",
+			},
+		},
+		"mismatched subsets, splits and filters": {
+			manifests: Manifests{
+				Rollouts: []*v1alpha1.Rollout{newTestRollout(pluginJson(), corev1.ConditionFalse, 0)},
+				Resolvers: []*consulv1aplha1.ServiceResolver{{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-service", Namespace: "default"},
+					Spec: consulv1aplha1.ServiceResolverSpec{
+						Subsets: map[string]consulv1aplha1.ServiceResolverSubset{
+							"stable": {Filter: "Service.Meta.version = 1"},
+						},
+					},
+				}},
+				Splitters: []*consulv1aplha1.ServiceSplitter{{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-service", Namespace: "default"},
+					Spec: consulv1aplha1.ServiceSplitterSpec{
+						Splits: []consulv1aplha1.ServiceSplit{
+							{Weight: 90, ServiceSubset: "stable"},
+							{Weight: 20, ServiceSubset: "v2"},
+						},
+					},
+				}},
+			},
+			expectedErrors: []string{
+				"ServiceResolver default/test-service: spec.subsets.stable.filter: invalid filter \"Service.Meta.version = 1\": 1:22 (21): no match found, expected: \"!=\", \"==\", \"contains\", \"in\", \"is\", \"matches\", \"not\" or [ \\t\\r\\n]",
+				"ServiceResolver default/test-service: spec.subsets.canary was not found, it is required by the rollout configuration",
+				"ServiceSplitter default/test-service: spec.splits[1].serviceSubset \"v2\" is neither the canary subset canary nor the stable subset stable",
+				"ServiceSplitter default/test-service: the weights of spec.splits add up to 110, expected 100",
+			},
+		},
+		"version from the deployment of the workloadRef": {
+			manifests: Manifests{
+				Rollouts:    []*v1alpha1.Rollout{workloadRefRollout("Deployment")},
+				Resolvers:   []*consulv1aplha1.ServiceResolver{defaultResolver()},
+				Splitters:   []*consulv1aplha1.ServiceSplitter{defaultSplitter()},
+				Deployments: []*appsv1.Deployment{testDeployment("2")},
+			},
+		},
+		"deployment of the workloadRef not in the manifests": {
+			manifests: Manifests{
+				Rollouts:  []*v1alpha1.Rollout{workloadRefRollout("Deployment")},
+				Resolvers: []*consulv1aplha1.ServiceResolver{defaultResolver()},
+				Splitters: []*consulv1aplha1.ServiceSplitter{defaultSplitter()},
+			},
+			expectedErrors: []string{
+				"Rollout default/rollout: deployment test-service referenced by spec.workloadRef was not found in the manifests",
+			},
+		},
+		"wrong number of splits": {
+			manifests: Manifests{
+				Rollouts:  []*v1alpha1.Rollout{newTestRollout(pluginJson(), corev1.ConditionFalse, 0)},
+				Resolvers: []*consulv1aplha1.ServiceResolver{defaultResolver()},
+				Splitters: []*consulv1aplha1.ServiceSplitter{{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-service"},
+					Spec: consulv1aplha1.ServiceSplitterSpec{
+						Splits: []consulv1aplha1.ServiceSplit{{Weight: 100, ServiceSubset: "stable"}},
+					},
+				}},
+			},
+			expectedErrors: []string{
+				"ServiceSplitter default/test-service: unexpected number of service splits. Expected 2, found 1",
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			p := &RpcPlugin{}
+			var actualErrors []string
+			for _, validationError := range p.Validate(testCase.manifests) {
+				actualErrors = append(actualErrors, validationError.Error())
+			}
+			require.Equal(t, testCase.expectedErrors, actualErrors)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// resolveWorkloadRef returns the rollout with the pod template of the Deployment it references in spec.workloadRef,
+// found with getDeployment. Rollouts with a template of their own, or whose template the rollouts controller has
+// already resolved, are returned as is.
+func resolveWorkloadRef(rollout *v1alpha1.Rollout, getDeployment func(name string) (*appsv1.Deployment, error)) (*v1alpha1.Rollout, error) {
+	ref := rollout.Spec.WorkloadRef
+	if ref == nil || len(rollout.Spec.Template.Spec.Containers) > 0 {
+		return rollout, nil
+	}
+	if ref.Kind != "Deployment" || (ref.APIVersion != "" && ref.APIVersion != appsv1.SchemeGroupVersion.String()) {
+		return nil, fmt.Errorf("spec.workloadRef of rollout %s/%s references a %s of %s, only Deployments of %s are supported",
+			rollout.GetNamespace(), rollout.GetName(), ref.Kind, ref.APIVersion, appsv1.SchemeGroupVersion)
+	}
+	deployment, err := getDeployment(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	resolved := rollout.DeepCopy()
+	resolved.Spec.Template = *deployment.Spec.Template.DeepCopy()
+	return resolved, nil
+}
+
+// withWorkloadTemplate resolves the pod template of a rollout using spec.workloadRef from the Deployment in the cluster
+// of the rollouts controller
+func (r *RpcPlugin) withWorkloadTemplate(ctx context.Context, rollout *v1alpha1.Rollout) (*v1alpha1.Rollout, error) {
+	return resolveWorkloadRef(rollout, func(name string) (*appsv1.Deployment, error) {
+		deployment := &appsv1.Deployment{}
+		if err := r.rolloutReader().Get(ctx, types.NamespacedName{Name: name, Namespace: rollout.GetNamespace()}, deployment); err != nil {
+			return nil, fmt.Errorf("deployment %s referenced by spec.workloadRef of rollout %s/%s could not be read: %w", name, rollout.GetNamespace(), rollout.GetName(), err)
+		}
+		return deployment, nil
+	})
+}
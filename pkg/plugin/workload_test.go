@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	consulv1aplha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// workloadRefRollout returns an in progress rollout referencing the test-service workload of kind, without a template
+func workloadRefRollout(kind string) *v1alpha1.Rollout {
+	rollout := newTestRollout(pluginJson(), corev1.ConditionFalse, 20)
+	rollout.Spec.Template = corev1.PodTemplateSpec{}
+	rollout.Spec.WorkloadRef = &v1alpha1.ObjectRef{APIVersion: "apps/v1", Kind: kind, Name: "test-service"}
+	return rollout
+}
+
+func testDeployment(version string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-service", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"consul.hashicorp.com/service-meta-version": version},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test-service", Image: "test-service:" + version}}},
+			},
+		},
+	}
+}
+
+func TestSetWeightWorkloadRef(t *testing.T) {
+	resolvedRollout := workloadRefRollout("Deployment")
+	resolvedRollout.Spec.Template = testDeployment("3").Spec.Template
+
+	testCases := map[string]struct {
+		rollout        *v1alpha1.Rollout
+		deployment     *appsv1.Deployment
+		expectedFilter string
+		expectedError  string
+	}{
+		"version from the referenced deployment": {
+			rollout:        workloadRefRollout("Deployment"),
+			deployment:     testDeployment("2"),
+			expectedFilter: `Service.Meta.version == "2"`,
+		},
+		"template already resolved by the rollouts controller": {
+			rollout:        resolvedRollout,
+			expectedFilter: `Service.Meta.version == "3"`,
+		},
+		"referenced deployment not found": {
+			rollout:       workloadRefRollout("Deployment"),
+			expectedError: `deployment test-service referenced by spec.workloadRef of rollout default/rollout could not be read: deployments.apps "test-service" not found`,
+		},
+		"unsupported workload kind": {
+			rollout:       workloadRefRollout("ReplicaSet"),
+			expectedError: "spec.workloadRef of rollout default/rollout references a ReplicaSet of apps/v1, only Deployments of apps/v1 are supported",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, consulv1aplha1.AddToScheme(s))
+			require.NoError(t, appsv1.AddToScheme(s))
+			objects := []client.Object{defaultResolver(), defaultSplitter()}
+			if testCase.deployment != nil {
+				objects = append(objects, testCase.deployment)
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(objects...).Build()
+			p := &RpcPlugin{
+				K8SClient: k8sClient,
+				IsTest:    true,
+				LogCtx:    logrus.NewEntry(logrus.New()),
+			}
+
+			rpcErr := p.SetWeight(testCase.rollout, 20, []v1alpha1.WeightDestination{})
+			actualResolver := &consulv1aplha1.ServiceResolver{}
+			require.NoError(t, k8sClient.Get(context.TODO(), types.NamespacedName{Name: "test-service", Namespace: "default"}, actualResolver, &client.GetOptions{}))
+			if testCase.expectedError != "" {
+				require.Equal(t, testCase.expectedError, rpcErr.ErrorString)
+				require.Equal(t, defaultResolver().Spec.Subsets, actualResolver.Spec.Subsets)
+				return
+			}
+			require.Empty(t, rpcErr.ErrorString)
+			require.Equal(t, testCase.expectedFilter, actualResolver.Spec.Subsets["canary"].Filter)
+		})
+	}
+}
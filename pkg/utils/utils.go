@@ -4,15 +4,69 @@
 package utils
 
 import (
+	"os"
+	"strconv"
+
 	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-func NewKubeConfig() (*rest.Config, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", "")
+// KubeConfigOptions select the cluster the plugin connects to and tune its client
+type KubeConfigOptions struct {
+	// Kubeconfig is the path of the kubeconfig file. If empty, $KUBECONFIG and the default locations are used, and the
+	// in-cluster config if none is found
+	Kubeconfig string
+	// Context is the kubeconfig context to use instead of the current context
+	Context string
+	// UserAgent is the user agent of the requests to the API server
+	UserAgent string
+	// QPS and Burst limit the rate of requests to the API server, the client-go defaults are used if zero
+	QPS   float32
+	Burst int
+	// Impersonate is the user the requests are made as, for example to check the permissions of a service account
+	Impersonate string
+}
+
+func NewKubeConfig(opts KubeConfigOptions) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = opts.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.Context}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
 	if err != nil {
 		return nil, pluginTypes.RpcError{ErrorString: err.Error()}
 	}
+	if opts.UserAgent != "" {
+		config.UserAgent = opts.UserAgent
+	}
+	if opts.Impersonate != "" {
+		config.Impersonate.UserName = opts.Impersonate
+	}
+	if opts.QPS > 0 {
+		config.QPS = opts.QPS
+	}
+	if opts.Burst > 0 {
+		config.Burst = opts.Burst
+	}
 	return config, nil
 }
+
+// EnvOrDefault returns the value of the environment variable key, or defaultValue if it is unset or empty
+func EnvOrDefault(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// EnvInt returns the value of the environment variable key as an int, or 0 if it is unset or invalid
+func EnvInt(key string) int {
+	value, _ := strconv.Atoi(os.Getenv(key))
+	return value
+}
+
+// EnvFloat returns the value of the environment variable key as a float, or 0 if it is unset or invalid
+func EnvFloat(key string) float64 {
+	value, _ := strconv.ParseFloat(os.Getenv(key), 64)
+	return value
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testKubeconfig = `
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+  - name: dev
+    cluster:
+      server: https://dev.example.com
+  - name: prod
+    cluster:
+      server: https://prod.example.com
+contexts:
+  - name: dev
+    context:
+      cluster: dev
+      user: user
+  - name: prod
+    context:
+      cluster: prod
+      user: user
+users:
+  - name: user
+    user:
+      token: token
+`
+
+func TestNewKubeConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600))
+
+	config, err := NewKubeConfig(KubeConfigOptions{Kubeconfig: kubeconfig})
+	require.NoError(t, err)
+	require.Equal(t, "https://dev.example.com", config.Host)
+
+	config, err = NewKubeConfig(KubeConfigOptions{Kubeconfig: kubeconfig, Context: "prod", UserAgent: "consul-plugin", QPS: 50, Burst: 100})
+	require.NoError(t, err)
+	require.Equal(t, "https://prod.example.com", config.Host)
+	require.Equal(t, "consul-plugin", config.UserAgent)
+	require.Equal(t, float32(50), config.QPS)
+	require.Equal(t, 100, config.Burst)
+
+	t.Setenv("KUBECONFIG", kubeconfig)
+	config, err = NewKubeConfig(KubeConfigOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "https://dev.example.com", config.Host)
+
+	_, err = NewKubeConfig(KubeConfigOptions{Kubeconfig: kubeconfig, Context: "missing"})
+	require.Error(t, err)
+}
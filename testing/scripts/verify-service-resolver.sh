@@ -9,6 +9,9 @@ if [ "$#" -lt 1 ]; then
     exit 1
 fi
 
+# The canary filter set by the plugin while no canary is in progress
+idle_filter='Service.Meta.argo_rollouts_idle_canary == "true"'
+
 # Run the kubectl command and save the output
 output=$(kubectl get serviceresolvers.consul.hashicorp.com -o yaml)
 
@@ -19,7 +22,7 @@ canary_filter=$(echo "$output" | yq e '.items[].spec.subsets.canary.filter' -)
 # Verify the filters
 if [ "$stable_filter" == "$1" ]; then
     if [ -z "$2" ]; then
-        if [ -z "$canary_filter" ]; then
+        if [ -z "$canary_filter" ] || [ "$canary_filter" == "$idle_filter" ]; then
             echo "Filters are as expected"
         else
             echo "Unexpected canary filter. Expected: None, Actual: $canary_filter"